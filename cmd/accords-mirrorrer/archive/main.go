@@ -3,29 +3,60 @@ package archive
 import (
 	"context"
 	"errors"
-
+	"fmt"
 	"log/slog"
-	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"sort"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
-	"git.vs49688.net/zane/goutils/download"
-
 	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
 	"git.vs49688.net/zane/accords-mirrorrer/cmd/accords-mirrorrer/config"
-	"git.vs49688.net/zane/accords-mirrorrer/library"
+	"git.vs49688.net/zane/accords-mirrorrer/mirror"
 )
 
 type configuration struct {
 	*config.Configuration
-	StateFile    string
-	Parallelism  int
-	DontRefresh  bool
-	DontDownload bool
+	StateFile     string
+	Parallelism   int
+	DontRefresh   bool
+	RefetchAfter  time.Duration
+	DontDownload  bool
+	DontPreflight bool
+	WarnFreeSpace bool
+	Recheck       bool
+	Only          []accords_mirrorrer.Ref
+	Order         string
+	RetryFailed   bool
+	RetryGone     bool
+
+	HostParallelism map[string]int
+
+	Profile      string
+	Include      cli.StringSlice
+	Exclude      cli.StringSlice
+	MaxFileSize  int64
+	Kinds        cli.StringSlice
+	Languages    cli.StringSlice
+	ClearProfile bool
+
+	Proxy               string
+	CABundle            string
+	ConnectTimeout      time.Duration
+	ResponseTimeout     time.Duration
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+
+	MaxRate   int64
+	MaxBytes  int64
+	StopAfter time.Duration
+	Windows   []mirror.Window
 }
 
 func RegisterCommand(app *cli.App, globalCfg *config.Configuration) *cli.App {
@@ -35,6 +66,9 @@ func RegisterCommand(app *cli.App, globalCfg *config.Configuration) *cli.App {
 		Parallelism:   0,
 		DontRefresh:   false,
 		DontDownload:  false,
+		Order:         mirror.DefaultOrder,
+
+		ConnectTimeout: 30 * time.Second,
 	}
 
 	app.Commands = append(app.Commands, &cli.Command{
@@ -53,220 +87,357 @@ func RegisterCommand(app *cli.App, globalCfg *config.Configuration) *cli.App {
 				Value:       cfg.Parallelism,
 				Destination: &cfg.Parallelism,
 			},
+			&cli.StringSliceFlag{
+				Name:  "host-parallelism",
+				Usage: "limit the parallelism of a host, e.g. resha.re=2, or * for every other host, may be repeated",
+				Action: func(c *cli.Context, ss []string) error {
+					cfg.HostParallelism = map[string]int{}
+
+					for _, s := range ss {
+						host, n, found := strings.Cut(s, "=")
+						limit, err := strconv.Atoi(n)
+						if !found || host == "" || err != nil || limit < 1 {
+							return fmt.Errorf("invalid host parallelism %q, expected host=n", s)
+						}
+
+						cfg.HostParallelism[host] = limit
+					}
+
+					return nil
+				},
+			},
 			&cli.BoolFlag{
 				Name:        "dont-refresh",
 				Usage:       "don't refresh the index, only download what we've got",
 				Value:       cfg.DontRefresh,
 				Destination: &cfg.DontRefresh,
 			},
+			&cli.DurationFlag{
+				Name:        "refetch-after",
+				Usage:       "fetch entities again if they were fetched longer than this ago, e.g. 720h, so their history records changes, 0 to never fetch them again",
+				Value:       cfg.RefetchAfter,
+				Destination: &cfg.RefetchAfter,
+			},
 			&cli.BoolFlag{
 				Name:        "dont-download",
 				Usage:       "don't download files, only refresh the index",
 				Value:       cfg.DontDownload,
 				Destination: &cfg.DontDownload,
 			},
-		},
-		Action: func(c *cli.Context) error { return archive(c.Context, &cfg) },
-	})
-
-	return app
-}
-
-func refreshIndex(ctx context.Context, state *accords_mirrorrer.State, client library.Client, hc *http.Client, log *slog.Logger) error {
-	if err := collectFolders(ctx, state, client, log); err != nil {
-		return err
-	}
-
-	if err := gatherContents(ctx, state, client, log); err != nil {
-		return err
-	}
-
-	if err := searchLibrary(ctx, state, client, log); err != nil {
-		return err
-	}
-
-	if err := searchWiki(ctx, state, client, log); err != nil {
-		return err
-	}
-
-	if err := searchWeaponStories(ctx, state, client, log); err != nil {
-		return err
-	}
-
-	{
-		chron, err := client.GetChronology(ctx)
-		if err != nil {
-			return err
-		}
-		state.RawChronology = chron.Raw
-		state.Chronology = chron
-	}
-
-	if err := searchPosts(ctx, state, client, log); err != nil {
-		return err
-	}
-
-	if err := gatherChronicles(ctx, state, client, log); err != nil {
-		return err
-	}
+			&cli.BoolFlag{
+				Name:        "dont-preflight",
+				Usage:       "don't size the downloads and check they'll fit in the free space before starting",
+				Value:       cfg.DontPreflight,
+				Destination: &cfg.DontPreflight,
+			},
+			&cli.BoolFlag{
+				Name:        "warn-free-space",
+				Usage:       "only warn if the downloads won't fit in the free space, rather than refusing to start",
+				Value:       cfg.WarnFreeSpace,
+				Destination: &cfg.WarnFreeSpace,
+			},
+			&cli.BoolFlag{
+				Name:        "recheck",
+				Usage:       "ask the server whether completed downloads have changed, downloading them again and keeping the old versions",
+				Value:       cfg.Recheck,
+				Destination: &cfg.Recheck,
+			},
+			&cli.StringSliceFlag{
+				Name:  "only",
+				Usage: "only refresh and download these entities and their subitems and contents, as kind:slug, e.g. library:some-item, may be repeated",
+				Action: func(c *cli.Context, ss []string) error {
+					for _, s := range ss {
+						ref, err := mirror.ParseEntity(s)
+						if err != nil {
+							return err
+						}
+
+						cfg.Only = append(cfg.Only, ref)
+					}
 
-	if err := searchVideos(ctx, state, client, log); err != nil {
-		return err
-	}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:        "order",
+				Usage:       fmt.Sprintf("order to download in, one of %v", strings.Join(mirror.Orders(), ", ")),
+				Value:       cfg.Order,
+				Destination: &cfg.Order,
+			},
+			&cli.BoolFlag{
+				Name:        "retry-failed",
+				Usage:       "retry failed downloads now, rather than backing off",
+				Value:       cfg.RetryFailed,
+				Destination: &cfg.RetryFailed,
+			},
+			&cli.BoolFlag{
+				Name:        "retry-gone",
+				Usage:       "retry downloads that returned 404 or 410",
+				Value:       cfg.RetryGone,
+				Destination: &cfg.RetryGone,
+			},
+			&cli.StringFlag{
+				Name:        "profile",
+				Usage:       "name of the saved download filters to apply, or to save the filters given to",
+				Value:       accords_mirrorrer.DefaultProfile,
+				Destination: &cfg.Profile,
+			},
+			&cli.StringSliceFlag{
+				Name:        "include",
+				Usage:       "only download urls or paths matching a glob (** matches across /), or re:regex, may be repeated",
+				Destination: &cfg.Include,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude",
+				Usage:       "don't download urls or paths matching a glob, or re:regex, may be repeated",
+				Destination: &cfg.Exclude,
+			},
+			&cli.StringFlag{
+				Name:  "max-file-size",
+				Usage: "don't download files known to be larger than this, e.g. 500M",
+				Action: func(c *cli.Context, s string) (err error) {
+					cfg.MaxFileSize, err = config.ParseBytes(s)
+					return err
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:        "kind",
+				Usage:       fmt.Sprintf("only download assets of these kinds, %v, or roles, may be repeated", strings.Join(sortedKeys(accords_mirrorrer.AssetKinds), ", ")),
+				Destination: &cfg.Kinds,
+			},
+			&cli.StringSliceFlag{
+				Name:        "languages",
+				Usage:       "only download per-language assets, such as translated audio and scan sets, in these language codes, e.g. en,ja",
+				Destination: &cfg.Languages,
+			},
+			&cli.BoolFlag{
+				Name:        "clear-profile",
+				Usage:       "remove the saved filters of the profile",
+				Value:       cfg.ClearProfile,
+				Destination: &cfg.ClearProfile,
+			},
+			&cli.StringFlag{
+				Name:        "proxy",
+				Usage:       "proxy url, http://, https://, socks5:// or socks5h://, defaults to $HTTPS_PROXY and friends",
+				Value:       cfg.Proxy,
+				Destination: &cfg.Proxy,
+			},
+			&cli.StringFlag{
+				Name:        "ca-bundle",
+				Usage:       "pem file of extra certificate authorities to trust",
+				Value:       cfg.CABundle,
+				Destination: &cfg.CABundle,
+			},
+			&cli.DurationFlag{
+				Name:        "connect-timeout",
+				Usage:       "timeout for connecting and the tls handshake",
+				Value:       cfg.ConnectTimeout,
+				Destination: &cfg.ConnectTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "response-timeout",
+				Usage:       "timeout for the response headers, 0 for none",
+				Value:       cfg.ResponseTimeout,
+				Destination: &cfg.ResponseTimeout,
+			},
+			&cli.IntFlag{
+				Name:        "max-conns-per-host",
+				Usage:       "maximum connections to each host, 0 for no limit",
+				Value:       cfg.MaxConnsPerHost,
+				Destination: &cfg.MaxConnsPerHost,
+			},
+			&cli.IntFlag{
+				Name:        "max-idle-conns-per-host",
+				Usage:       "idle connections to keep open to each host, 0 for the default",
+				Value:       cfg.MaxIdleConnsPerHost,
+				Destination: &cfg.MaxIdleConnsPerHost,
+			},
+			&cli.StringFlag{
+				Name:  "max-rate",
+				Usage: "maximum download rate in bytes per second, across all downloads, e.g. 2M, 0 for no limit",
+				Value: "0",
+				Action: func(c *cli.Context, s string) (err error) {
+					cfg.MaxRate, err = config.ParseBytes(s)
+					return err
+				},
+			},
+			&cli.StringFlag{
+				Name:  "max-bytes",
+				Usage: "stop downloading after receiving this many bytes, e.g. 50G, 0 for no limit",
+				Value: "0",
+				Action: func(c *cli.Context, s string) (err error) {
+					cfg.MaxBytes, err = config.ParseBytes(s)
+					return err
+				},
+			},
+			&cli.DurationFlag{
+				Name:        "stop-after",
+				Usage:       "stop downloading after running this long, 0 for no limit",
+				Value:       cfg.StopAfter,
+				Destination: &cfg.StopAfter,
+			},
+			&cli.StringSliceFlag{
+				Name:  "window",
+				Usage: "only download between these local times of day, e.g. 22:00-06:00, may be repeated",
+				Action: func(c *cli.Context, ss []string) error {
+					for _, s := range ss {
+						w, err := mirror.ParseWindow(s)
+						if err != nil {
+							return err
+						}
+
+						cfg.Windows = append(cfg.Windows, w)
+					}
 
-	if err := gatherVideoChannels(ctx, state, client, log); err != nil {
-		return err
-	}
+					return nil
+				},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			// Any filter replaces the whole saved profile.
+			var setProfile *accords_mirrorrer.Profile
+			for _, name := range []string{"include", "exclude", "max-file-size", "kind", "languages"} {
+				if !c.IsSet(name) {
+					continue
+				}
 
-	// Now do a brute-force scrape of the actual storage, just in case. Thankfully, they've got directory listing enabled.
-	{
-		resURLs, err := download.ScrapeIndex(ctx, &url.URL{Scheme: "https", Host: "resha.re", Path: "/accords/"}, hc, log)
-		if err != nil {
-			log.With(slog.Any("error", err)).ErrorContext(ctx, "error scraping urls")
-			return err
-		}
+				if cfg.ClearProfile {
+					return fmt.Errorf("--clear-profile and --%v are mutually exclusive", name)
+				}
 
-		for _, u := range resURLs {
-			if _, err := state.AddDownload(u.String()); err != nil {
-				log.With(slog.Any("error", err), slog.String("url", u.String())).ErrorContext(ctx, "error scraping urls")
-				continue
+				setProfile = &accords_mirrorrer.Profile{
+					Include:     cfg.Include.Value(),
+					Exclude:     cfg.Exclude.Value(),
+					MaxFileSize: cfg.MaxFileSize,
+					Kinds:       cfg.Kinds.Value(),
+					Languages:   cfg.Languages.Value(),
+				}
 			}
-		}
-	}
 
-	return nil
-}
+			if cfg.ClearProfile {
+				setProfile = &accords_mirrorrer.Profile{}
+			}
 
-type transport struct {
-	cfg *configuration
-}
+			return archive(c.Context, &cfg, setProfile)
+		},
+	})
 
-func (t transport) RoundTrip(request *http.Request) (*http.Response, error) {
-	request.Header.Set("User-Agent", t.cfg.UserAgent)
-	return http.DefaultTransport.RoundTrip(request)
+	return app
 }
 
-func archive(ctx context.Context, cfg *configuration) error {
-	l := cfg.Logger
-
-	state, err := accords_mirrorrer.LoadState(cfg.StateFile)
-	if err != nil {
-		return err
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	l.InfoContext(ctx, "loaded state")
+	sort.Strings(keys)
 
-	hc := &http.Client{Transport: transport{cfg: cfg}}
-	lc := library.NewClient(hc)
-
-	if !cfg.DontRefresh {
-		if err := refreshIndex(ctx, state, lc, hc, l); err != nil {
-			if err2 := accords_mirrorrer.SaveState(state, cfg.StateFile); err2 != nil {
-				err = errors.Join(err, err2)
-			}
-			return err
-		}
-	} else {
-		l.InfoContext(ctx, "skipping index refresh by request")
-	}
+	return keys
+}
 
-	processStateOpenGraph(state, l)
+func archive(ctx context.Context, cfg *configuration, setProfile *accords_mirrorrer.Profile) error {
+	l := cfg.Logger
 
-	for _, folder := range state.Folders {
-		for _, ct := range folder.Contents {
-			addUploadImageFragment(ct.Thumbnail, lc, state, l)
+	var proxy *url.URL
+	if cfg.Proxy != "" {
+		var err error
+		if proxy, err = url.Parse(cfg.Proxy); err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
 		}
 	}
 
-	for _, ct := range state.Content {
-		extractContents(ct.Content, lc, state, l)
-	}
-
-	for _, item := range state.Library {
-		extractLibraryItem(item.Item, lc, state, l)
-	}
-
-	for _, item := range state.Reader {
-		extractLibraryItem(item.Item, lc, state, l)
-	}
-
-	for _, item := range state.Wiki {
-		addUploadFileEntityResponse(item.Page.Thumbnail, lc, state, l)
+	m, err := mirror.New(mirror.Options{
+		StateFile:       cfg.StateFile,
+		Parallelism:     cfg.Parallelism,
+		HostParallelism: cfg.HostParallelism,
+		DontRefresh:     cfg.DontRefresh,
+		RefetchAfter:    cfg.RefetchAfter,
+		DontDownload:    cfg.DontDownload,
+		DontPreflight:   cfg.DontPreflight,
+		WarnFreeSpace:   cfg.WarnFreeSpace,
+		Recheck:         cfg.Recheck,
+		Only:            cfg.Only,
+		Profile:         cfg.Profile,
+		SetProfile:      setProfile,
+		Order:           cfg.Order,
+		RetryFailed:     cfg.RetryFailed,
+		RetryGone:       cfg.RetryGone,
+		MaxRate:         cfg.MaxRate,
+		MaxBytes:        cfg.MaxBytes,
+		StopAfter:       cfg.StopAfter,
+		Windows:         cfg.Windows,
+		HTTP: mirror.HTTPOptions{
+			UserAgent:           cfg.UserAgent,
+			Proxy:               proxy,
+			CABundle:            cfg.CABundle,
+			ConnectTimeout:      cfg.ConnectTimeout,
+			ResponseTimeout:     cfg.ResponseTimeout,
+			MaxConnsPerHost:     cfg.MaxConnsPerHost,
+			MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		},
+		Logger: l,
+	})
+	if err != nil {
+		return err
 	}
 
-	for _, item := range state.WeaponStories {
-		extractWeaponStory(item.Weapon, lc, state, l)
+	// The downloads are saved relative to the working directory.
+	if err := m.Open(ctx); err != nil {
+		return err
 	}
 
-	for _, item := range state.Posts {
-		addUploadFileEntityResponse(item.Post.Thumbnail, lc, state, l)
-
-		for _, tl := range item.Post.Translations {
-			addUploadFileEntityResponse(tl.Thumbnail, lc, state, l)
+	defer func() {
+		if err := m.Close(); err != nil {
+			l.With(slog.Any("error", err)).ErrorContext(ctx, "error releasing lock")
 		}
+	}()
 
-	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	for _, item := range state.Videos {
-		extractVideo(item, lc, state, l)
-	}
-
-	for _, item := range state.Chronicles.Entries {
-		extractChronicle(&item.Chronicle, lc, state, l)
-	}
-
-	l.InfoContext(ctx, "index update finished...")
-
-	if !cfg.DontDownload {
-		reqs := make([]*download.Request, 0, len(state.Downloads))
-		for _, di := range state.Downloads {
-			if !di.Completed {
-				req, _ := download.MakeRequest(ctx, di, l)
-				reqs = append(reqs, req)
-			}
-		}
-
-		sort.Slice(reqs, func(i, j int) bool {
-			return reqs[i].GetURL().String() < reqs[j].GetURL().String()
-		})
+	sigChan := make(chan os.Signal, 10)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, SIGUSR1)
+	defer signal.Stop(sigChan)
 
+	go func() {
 		numInterrupts := 0
 
-		err, _ = download.RunAll(ctx, download.Options{
-			Requests:    reqs,
-			Parallelism: cfg.Parallelism,
-			Client:      http.DefaultClient,
-			UserAgent:   accords_mirrorrer.UserAgent,
-			Logger:      l,
-			Signals:     []os.Signal{syscall.SIGINT, syscall.SIGTERM, SIGUSR1},
-			SignalHandler: func(ctx context.Context, sig os.Signal, cancel func()) error {
+		for {
+			select {
+			case sig := <-sigChan:
+				l.With(slog.String("signal", sig.String())).InfoContext(ctx, "caught signal")
+
 				if sig == SIGUSR1 {
-					if err := accords_mirrorrer.SaveState(state, cfg.StateFile); err != nil {
-						return err
+					if err := m.Checkpoint(); err != nil {
+						l.With(slog.Any("error", err)).ErrorContext(ctx, "error saving state")
 					}
-				} else {
-					cancel()
+					continue
+				}
 
-					numInterrupts += 1
+				m.Stop()
 
-					if numInterrupts == 1 {
-						l.InfoContext(ctx, "interrupt received, stopping new downloads")
-						l.InfoContext(ctx, "interrupt 2 more times to stop current")
-					}
+				numInterrupts += 1
 
-					if numInterrupts >= 3 {
-						return errors.New("aborting")
-					}
+				if numInterrupts == 1 {
+					l.InfoContext(ctx, "interrupt received, stopping new downloads")
+					l.InfoContext(ctx, "interrupt 2 more times to stop current")
 				}
-				return nil
-			},
-		})
-	} else {
-		l.InfoContext(ctx, "skipping download by request")
-	}
 
-	if err2 := accords_mirrorrer.SaveState(state, cfg.StateFile); err2 != nil {
-		err = errors.Join(err, err2)
+				if numInterrupts >= 3 {
+					l.InfoContext(ctx, "aborting")
+					cancel()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Stopping on request is a clean exit, the state has been saved.
+	if err := m.Run(ctx); !errors.Is(err, mirror.ErrStopped) {
+		return err
 	}
 
-	return err
+	l.InfoContext(ctx, "stopped")
+	return nil
 }
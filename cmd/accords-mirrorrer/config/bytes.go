@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseBytes parses a size such as "512", "10K", "1.5GiB" or "2GB". Units are binary.
+func ParseBytes(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+
+	unit := strings.TrimLeft(num, "0123456789.")
+	num = strings.TrimSpace(num[:len(num)-len(unit)])
+	unit = strings.ToUpper(strings.TrimSpace(unit))
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+
+	exp := 0
+	if unit != "" {
+		exp = strings.Index("KMGTPE", unit) + 1
+		if exp == 0 || len(unit) != 1 {
+			return 0, fmt.Errorf("invalid size %q", s)
+		}
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	for ; exp > 0; exp -= 1 {
+		n *= 1024
+	}
+
+	return int64(n), nil
+}
@@ -0,0 +1,197 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+type kindDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+type fieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type downloadChange struct {
+	URL     string        `json:"url"`
+	Changes []fieldChange `json:"changes"`
+}
+
+type downloadsDiff struct {
+	Added   []string         `json:"added,omitempty"`
+	Removed []string         `json:"removed,omitempty"`
+	Changed []downloadChange `json:"changed,omitempty"`
+}
+
+type stateDiff struct {
+	Entities  map[string]*kindDiff `json:"entities"`
+	Downloads downloadsDiff        `json:"downloads"`
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func diffEntities(a, b map[string]*accords_mirrorrer.RawEntity) *kindDiff {
+	kd := &kindDiff{}
+
+	for _, slug := range sortedKeys(a) {
+		eb, exists := b[slug]
+		if !exists || eb == nil {
+			kd.Removed = append(kd.Removed, slug)
+		} else if ea := a[slug]; ea == nil || ea.SHA256 != eb.SHA256 {
+			kd.Changed = append(kd.Changed, slug)
+		}
+	}
+
+	for _, slug := range sortedKeys(b) {
+		if ea, exists := a[slug]; !exists || ea == nil {
+			kd.Added = append(kd.Added, slug)
+		}
+	}
+
+	return kd
+}
+
+func diffDownload(a, b *accords_mirrorrer.Download) []fieldChange {
+	var changes []fieldChange
+
+	check := func(field, old, new string) {
+		if old != new {
+			changes = append(changes, fieldChange{Field: field, Old: old, New: new})
+		}
+	}
+
+	check("url", a.URL, b.URL)
+	check("out_path", a.OutPath, b.OutPath)
+	check("size", strconv.FormatInt(a.Size, 10), strconv.FormatInt(b.Size, 10))
+	check("sha256", a.SHA256, b.SHA256)
+
+	return changes
+}
+
+func diffDownloads(a, b map[string]*accords_mirrorrer.Download) downloadsDiff {
+	dd := downloadsDiff{}
+
+	for _, u := range sortedKeys(a) {
+		db, exists := b[u]
+		if !exists {
+			dd.Removed = append(dd.Removed, u)
+			continue
+		}
+
+		if changes := diffDownload(a[u], db); len(changes) > 0 {
+			dd.Changed = append(dd.Changed, downloadChange{URL: u, Changes: changes})
+		}
+	}
+
+	for _, u := range sortedKeys(b) {
+		if _, exists := a[u]; !exists {
+			dd.Added = append(dd.Added, u)
+		}
+	}
+
+	return dd
+}
+
+func diffStates(a, b *accords_mirrorrer.State) *stateDiff {
+	sd := &stateDiff{Entities: map[string]*kindDiff{}}
+
+	ea, eb := a.RawEntities(), b.RawEntities()
+	for kind, entities := range ea {
+		sd.Entities[kind] = diffEntities(entities, eb[kind])
+	}
+
+	sd.Downloads = diffDownloads(a.Downloads, b.Downloads)
+	return sd
+}
+
+func writeDiffText(w io.Writer, sd *stateDiff) {
+	for _, kind := range sortedKeys(sd.Entities) {
+		kd := sd.Entities[kind]
+		if len(kd.Added)+len(kd.Removed)+len(kd.Changed) == 0 {
+			continue
+		}
+
+		_, _ = fmt.Fprintf(w, "%s: +%d -%d ~%d\n", kind, len(kd.Added), len(kd.Removed), len(kd.Changed))
+		for _, slug := range kd.Added {
+			_, _ = fmt.Fprintf(w, "  + %s\n", slug)
+		}
+		for _, slug := range kd.Removed {
+			_, _ = fmt.Fprintf(w, "  - %s\n", slug)
+		}
+		for _, slug := range kd.Changed {
+			_, _ = fmt.Fprintf(w, "  ~ %s\n", slug)
+		}
+	}
+
+	dd := sd.Downloads
+	if len(dd.Added)+len(dd.Removed)+len(dd.Changed) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "downloads: +%d -%d ~%d\n", len(dd.Added), len(dd.Removed), len(dd.Changed))
+	for _, u := range dd.Added {
+		_, _ = fmt.Fprintf(w, "  + %s\n", u)
+	}
+	for _, u := range dd.Removed {
+		_, _ = fmt.Fprintf(w, "  - %s\n", u)
+	}
+	for _, dc := range dd.Changed {
+		_, _ = fmt.Fprintf(w, "  ~ %s\n", dc.URL)
+		for _, fc := range dc.Changes {
+			_, _ = fmt.Fprintf(w, "      %s: %q -> %q\n", fc.Field, fc.Old, fc.New)
+		}
+	}
+}
+
+func diffStateFiles(_ context.Context, pathA, pathB string, format string) error {
+	// A missing state would load as an empty one.
+	for _, p := range []string{pathA, pathB} {
+		if _, err := os.Stat(p); err != nil {
+			return err
+		}
+	}
+
+	a, err := accords_mirrorrer.LoadStateReadOnly(pathA)
+	if err != nil {
+		return fmt.Errorf("error loading %v: %w", pathA, err)
+	}
+
+	b, err := accords_mirrorrer.LoadStateReadOnly(pathB)
+	if err != nil {
+		return fmt.Errorf("error loading %v: %w", pathB, err)
+	}
+
+	sd := diffStates(a, b)
+
+	switch format {
+	case "text":
+		writeDiffText(os.Stdout, sd)
+		return nil
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sd)
+	default:
+		return fmt.Errorf("unknown format: %v", format)
+	}
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+func TestDiffStates(t *testing.T) {
+	a, b := accords_mirrorrer.NewState(), accords_mirrorrer.NewState()
+
+	same, _ := a.AddDownload("https://a.example.com/same.pdf")
+	same.Size = 1
+	changed, _ := a.AddDownload("https://a.example.com/changed.pdf")
+	changed.Size = 1
+	_, _ = a.AddDownload("https://a.example.com/removed.pdf")
+
+	same, _ = b.AddDownload("https://a.example.com/same.pdf")
+	same.Size = 1
+	changed, _ = b.AddDownload("https://a.example.com/changed.pdf")
+	changed.Size = 2
+	_, _ = b.AddDownload("https://a.example.com/added.pdf")
+
+	dd := diffStates(a, b).Downloads
+	if len(dd.Added) != 1 || dd.Added[0] != "https://a.example.com/added.pdf" {
+		t.Errorf("added = %v", dd.Added)
+	}
+
+	if len(dd.Removed) != 1 || dd.Removed[0] != "https://a.example.com/removed.pdf" {
+		t.Errorf("removed = %v", dd.Removed)
+	}
+
+	if len(dd.Changed) != 1 || dd.Changed[0].URL != "https://a.example.com/changed.pdf" {
+		t.Errorf("changed = %v", dd.Changed)
+	}
+}
+
+func TestDiffStateFilesMissing(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "state.json")
+	missing := filepath.Join(dir, "missing.json")
+
+	if err := accords_mirrorrer.SaveState(accords_mirrorrer.NewState(), existing); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, paths := range [][2]string{{existing, missing}, {missing, existing}} {
+		if err := diffStateFiles(context.Background(), paths[0], paths[1], "json"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%v: expected a missing file error, got %v", paths, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+type failuresOptions struct {
+	Format string
+	Status string
+}
+
+type failureRecord struct {
+	URL         string     `json:"url"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	HTTPStatus  int        `json:"http_status,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	LastError   string     `json:"last_error"`
+}
+
+func formatAttemptTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
+
+func listFailures(ctx context.Context, cfg *configuration, opts failuresOptions) error {
+	switch opts.Status {
+	case "", accords_mirrorrer.StatusFailed, accords_mirrorrer.StatusGone:
+	default:
+		return fmt.Errorf("unknown status %q, expected %v or %v", opts.Status, accords_mirrorrer.StatusFailed, accords_mirrorrer.StatusGone)
+	}
+
+	state, err := loadStateReadOnly(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	records := make([]failureRecord, 0)
+	for _, u := range sortedKeys(state.Downloads) {
+		di := state.Downloads[u]
+
+		status := di.Status()
+		if status != accords_mirrorrer.StatusFailed && status != accords_mirrorrer.StatusGone {
+			continue
+		}
+
+		if opts.Status != "" && status != opts.Status {
+			continue
+		}
+
+		rec := failureRecord{
+			URL:         di.URL,
+			Status:      status,
+			Attempts:    di.Attempts,
+			HTTPStatus:  di.LastStatus,
+			LastAttempt: di.LastAttempt,
+			LastError:   di.LastError,
+		}
+
+		// Gone downloads aren't retried at all, without --retry-gone.
+		if next := di.NextAttempt(); status == accords_mirrorrer.StatusFailed && !next.IsZero() {
+			rec.NextAttempt = &next
+		}
+
+		records = append(records, rec)
+	}
+
+	switch opts.Format {
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "URL\tSTATUS\tATTEMPTS\tHTTP\tLAST ATTEMPT\tNEXT ATTEMPT\tERROR")
+		for _, rec := range records {
+			httpStatus := "-"
+			if rec.HTTPStatus != 0 {
+				httpStatus = fmt.Sprint(rec.HTTPStatus)
+			}
+
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+				rec.URL, rec.Status, rec.Attempts, httpStatus,
+				formatAttemptTime(rec.LastAttempt), formatAttemptTime(rec.NextAttempt), rec.LastError,
+			)
+		}
+		return w.Flush()
+	case "json":
+		return writeJSON(records)
+	default:
+		return fmt.Errorf("unknown format: %v", opts.Format)
+	}
+}
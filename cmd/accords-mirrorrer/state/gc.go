@@ -0,0 +1,153 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+	"git.vs49688.net/zane/accords-mirrorrer/mirror"
+)
+
+type gcOptions struct {
+	Root       string
+	Delete     bool
+	Quarantine string
+}
+
+// quarantineFile moves a file under dir, keeping its path relative to the root.
+func quarantineFile(root, rel, dir string) error {
+	dst := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(root, filepath.FromSlash(rel)), dst)
+}
+
+func collectGarbage(ctx context.Context, cfg *configuration, opts gcOptions) error {
+	if opts.Delete && opts.Quarantine != "" {
+		return errors.New("--delete and --quarantine are mutually exclusive")
+	}
+
+	log := cfg.Configuration.Logger
+
+	state, release, err := openState(ctx, cfg, opts.Delete || opts.Quarantine != "", "state gc", opts.Root)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	reachable, err := mirror.ReachableDownloads(state, log)
+	if err != nil {
+		return err
+	}
+
+	if state.Scraped == nil {
+		log.WarnContext(ctx, "no scrape results in the state, assuming everything in the asset storage is referenced")
+
+		prefix := mirror.ScrapeRoot.String()
+		for u := range state.Downloads {
+			if strings.HasPrefix(u, prefix) {
+				reachable[u] = struct{}{}
+			}
+		}
+	}
+
+	var unreferenced []string
+	known := make(map[string]struct{}, len(state.Downloads))
+	for _, u := range sortedKeys(state.Downloads) {
+		known[state.Downloads[u].OutPath] = struct{}{}
+		for _, v := range state.Downloads[u].Versions {
+			known[v.OutPath] = struct{}{}
+		}
+
+		if _, exists := reachable[u]; !exists {
+			unreferenced = append(unreferenced, u)
+		}
+	}
+
+	skip := []string{cfg.StateFile}
+	if opts.Quarantine != "" {
+		skip = append(skip, opts.Quarantine)
+	}
+
+	files, err := walkFiles(opts.Root, skip...)
+	if err != nil {
+		return err
+	}
+
+	// Only look inside host directories.
+	var orphans []string
+	for _, f := range files {
+		if _, ok := urlFromOutPath(f.Rel); !ok {
+			continue
+		}
+
+		if _, exists := known[f.Rel]; !exists {
+			orphans = append(orphans, f.Rel)
+		}
+	}
+
+	for _, u := range unreferenced {
+		fmt.Printf("unreferenced\t%s\t%s\n", u, state.Downloads[u].OutPath)
+	}
+
+	for _, rel := range orphans {
+		fmt.Printf("orphan\t%s\n", rel)
+	}
+
+	log.With(slog.Int("unreferenced", len(unreferenced)), slog.Int("orphans", len(orphans))).InfoContext(ctx, "garbage collection finished")
+
+	if !opts.Delete && opts.Quarantine == "" {
+		log.InfoContext(ctx, "dry run, nothing changed. use --delete or --quarantine to clean up")
+		return nil
+	}
+
+	remove := func(rel string) error {
+		if opts.Delete {
+			return os.Remove(filepath.Join(opts.Root, filepath.FromSlash(rel)))
+		}
+
+		return quarantineFile(opts.Root, rel, opts.Quarantine)
+	}
+
+	var errs []error
+
+	for _, u := range unreferenced {
+		rels := []string{state.Downloads[u].OutPath}
+		for _, v := range state.Downloads[u].Versions {
+			rels = append(rels, v.OutPath)
+		}
+
+		var failed bool
+		for _, rel := range rels {
+			if err := remove(rel); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.With(slog.String("path", rel), slog.Any("error", err)).ErrorContext(ctx, "error removing file")
+				errs = append(errs, err)
+				failed = true
+			}
+		}
+
+		if !failed {
+			delete(state.Downloads, u)
+		}
+	}
+
+	for _, rel := range orphans {
+		if err := remove(rel); err != nil {
+			log.With(slog.String("path", rel), slog.Any("error", err)).ErrorContext(ctx, "error removing file")
+			errs = append(errs, err)
+		}
+	}
+
+	if err := accords_mirrorrer.SaveState(state, cfg.StateFile); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,41 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+func TestCollectGarbageQuarantine(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfiguration(root)
+
+	// The quarantine directory looks like a host directory, and holds a previous run's orphan.
+	quarantine := filepath.Join(root, "gc.quarantine")
+
+	writeFiles(t, root, map[string]string{
+		"a.example.com/orphan.pdf":               "orphan",
+		"gc.quarantine/a.example.com/before.pdf": "before",
+	})
+
+	if err := accords_mirrorrer.SaveState(accords_mirrorrer.NewState(), cfg.StateFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := collectGarbage(context.Background(), cfg, gcOptions{Root: root, Quarantine: quarantine}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rel := range []string{"a.example.com/orphan.pdf", "a.example.com/before.pdf"} {
+		if _, err := os.Stat(filepath.Join(quarantine, filepath.FromSlash(rel))); err != nil {
+			t.Errorf("%v isn't in the quarantine: %v", rel, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "a.example.com", "orphan.pdf")); !os.IsNotExist(err) {
+		t.Errorf("orphan wasn't moved: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+func entityKinds(state *accords_mirrorrer.State) []string {
+	kinds := make([]string, 0)
+	for k := range state.RawEntities() {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+func lookupEntity(state *accords_mirrorrer.State, kind, slug string) (*accords_mirrorrer.RawEntity, error) {
+	entities, exists := state.RawEntities()[kind]
+	if !exists {
+		return nil, fmt.Errorf("unknown kind %q, expected one of: %s", kind, strings.Join(entityKinds(state), ", "))
+	}
+
+	e, exists := entities[slug]
+	if !exists || e == nil {
+		return nil, fmt.Errorf("no such %v: %q", kind, slug)
+	}
+
+	return e, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid timestamp: %q", s)
+}
+
+func formatFetchedAt(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func entityHistory(ctx context.Context, cfg *configuration, kind, slug string) error {
+	state, err := loadStateReadOnly(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	e, err := lookupEntity(state, kind, slug)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tFETCHED AT\tSHA256\tSIZE\tSOURCE")
+
+	for i, v := range e.Versions() {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", i, formatFetchedAt(v.FetchedAt), v.SHA256, len(v.Data), v.Source)
+	}
+
+	return w.Flush()
+}
+
+type showOptions struct {
+	At   string
+	Diff bool
+}
+
+func entityShow(ctx context.Context, cfg *configuration, kind, slug string, opts showOptions) error {
+	state, err := loadStateReadOnly(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	current, err := lookupEntity(state, kind, slug)
+	if err != nil {
+		return err
+	}
+
+	version := current
+	if opts.At != "" {
+		at, err := parseTime(opts.At)
+		if err != nil {
+			return err
+		}
+
+		if version = current.At(at); version == nil {
+			return fmt.Errorf("%v %q didn't exist at %v", kind, slug, at.Format(time.RFC3339))
+		}
+	}
+
+	if opts.Diff {
+		changes, err := diffJSON(version.Data, current.Data)
+		if err != nil {
+			return err
+		}
+
+		return writeJSONChanges(os.Stdout, changes)
+	}
+
+	b, err := json.MarshalIndent(version.Data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Println(string(b))
+	return err
+}
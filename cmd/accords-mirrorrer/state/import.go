@@ -0,0 +1,271 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+const (
+	importModeLink = "link"
+	importModeCopy = "copy"
+)
+
+type importOptions struct {
+	Root        string
+	Mode        string
+	Parallelism int
+	DryRun      bool
+}
+
+type importMatch struct {
+	di   *accords_mirrorrer.Download
+	file *localFile
+}
+
+// pathKeys returns the paths a download may be found under in a foreign mirror,
+// with and without the host directory.
+func pathKeys(di *accords_mirrorrer.Download) []string {
+	keys := []string{di.OutPath}
+
+	if u, err := url.Parse(di.URL); err == nil {
+		keys = append(keys, strings.TrimPrefix(u.Path, "/"))
+	}
+
+	return keys
+}
+
+// matchByPath pairs foreign files with pending downloads by relative path. A foreign
+// file may be nested one level down, as Internet Archive items are.
+func matchByPath(pending []*accords_mirrorrer.Download, files []*localFile) []importMatch {
+	byPath := map[string]*accords_mirrorrer.Download{}
+	for _, di := range pending {
+		for _, k := range pathKeys(di) {
+			if _, exists := byPath[k]; !exists {
+				byPath[k] = di
+			}
+		}
+	}
+
+	var matches []importMatch
+	claimed := map[*accords_mirrorrer.Download]struct{}{}
+
+	for _, f := range files {
+		candidates := []string{f.Rel}
+		if _, rest, found := strings.Cut(f.Rel, "/"); found {
+			candidates = append(candidates, rest)
+		}
+
+		for _, c := range candidates {
+			di, exists := byPath[c]
+			if !exists {
+				continue
+			}
+
+			if _, done := claimed[di]; done {
+				continue
+			}
+
+			claimed[di] = struct{}{}
+			matches = append(matches, importMatch{di: di, file: f})
+			break
+		}
+	}
+
+	return matches
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	tmp := dst + ".tmp"
+
+	// #nosec G302 - Because these actually need to be readable.
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+// placeFile puts src at dst, hard-linking if requested and possible.
+func placeFile(src, dst string, mode string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// There may be a partial download in the way.
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if mode == importModeLink {
+		if err := os.Link(src, dst); err == nil {
+			return nil
+		}
+	}
+
+	return copyFile(src, dst)
+}
+
+func importDir(ctx context.Context, cfg *configuration, dir string, opts importOptions) error {
+	switch opts.Mode {
+	case importModeLink, importModeCopy:
+	default:
+		return fmt.Errorf("unknown import mode: %v", opts.Mode)
+	}
+
+	log := cfg.Configuration.Logger
+
+	state, release, err := openState(ctx, cfg, !opts.DryRun, "state import-dir", opts.Root)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var pending []*accords_mirrorrer.Download
+	for _, u := range sortedKeys(state.Downloads) {
+		if di := state.Downloads[u]; !di.Completed {
+			pending = append(pending, di)
+		}
+	}
+
+	files, err := walkFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	log.With(slog.Int("pending", len(pending)), slog.Int("files", len(files))).InfoContext(ctx, "matching files")
+
+	pathMatches := matchByPath(pending, files)
+
+	// Anything left over can be matched by content, if we know what it should be.
+	bySize := map[int64][]*accords_mirrorrer.Download{}
+	for _, di := range pending {
+		if di.SHA256 != "" && di.Size > 0 {
+			bySize[di.Size] = append(bySize[di.Size], di)
+		}
+	}
+
+	pathMatched := make(map[*localFile]struct{}, len(pathMatches))
+	toHash := make([]*localFile, 0, len(pathMatches))
+	for _, m := range pathMatches {
+		pathMatched[m.file] = struct{}{}
+		toHash = append(toHash, m.file)
+	}
+
+	var sizeCandidates []*localFile
+	for _, f := range files {
+		if _, matched := pathMatched[f]; matched {
+			continue
+		}
+
+		fi, err := os.Stat(f.Path)
+		if err != nil {
+			continue
+		}
+
+		if _, exists := bySize[fi.Size()]; exists {
+			sizeCandidates = append(sizeCandidates, f)
+			toHash = append(toHash, f)
+		}
+	}
+
+	log.With(slog.Int("files", len(toHash))).InfoContext(ctx, "hashing candidate files")
+
+	errs := hashFiles(ctx, toHash, opts.Parallelism)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashErrors := make(map[*localFile]error, len(toHash))
+	for i, f := range toHash {
+		if errs[i] != nil {
+			hashErrors[f] = errs[i]
+		}
+	}
+
+	var matches []importMatch
+	claimed := map[*accords_mirrorrer.Download]struct{}{}
+
+	for _, m := range pathMatches {
+		if err := hashErrors[m.file]; err != nil {
+			log.With(slog.String("path", m.file.Path), slog.Any("error", err)).ErrorContext(ctx, "error hashing file")
+			continue
+		}
+
+		probe := *m.di
+		if !adoptFile(&probe, m.file) {
+			log.With(slog.String("path", m.file.Path), slog.String("url", m.di.URL)).WarnContext(ctx, "path matches, but content doesn't")
+			continue
+		}
+
+		claimed[m.di] = struct{}{}
+		matches = append(matches, m)
+	}
+
+	for _, f := range sizeCandidates {
+		if hashErrors[f] != nil {
+			continue
+		}
+
+		for _, di := range bySize[f.Size] {
+			if _, done := claimed[di]; done || di.SHA256 != f.SHA256 {
+				continue
+			}
+
+			claimed[di] = struct{}{}
+			matches = append(matches, importMatch{di: di, file: f})
+			break
+		}
+	}
+
+	numImported := 0
+	for _, m := range matches {
+		l := log.With(slog.String("src", m.file.Path), slog.String("url", m.di.URL))
+
+		if opts.DryRun {
+			l.InfoContext(ctx, "would import")
+			continue
+		}
+
+		if err := placeFile(m.file.Path, filepath.Join(opts.Root, filepath.FromSlash(m.di.OutPath)), opts.Mode); err != nil {
+			l.With(slog.Any("error", err)).ErrorContext(ctx, "error importing file")
+			continue
+		}
+
+		adoptFile(m.di, m.file)
+		numImported += 1
+		l.DebugContext(ctx, "imported")
+	}
+
+	log.With(slog.Int("matched", len(matches)), slog.Int("imported", numImported)).InfoContext(ctx, "import finished")
+
+	if opts.DryRun || numImported == 0 {
+		return nil
+	}
+
+	return accords_mirrorrer.SaveState(state, cfg.StateFile)
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type jsonChange struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+func diffJSON(a, b json.RawMessage) ([]jsonChange, error) {
+	var va, vb any
+
+	if len(a) > 0 {
+		if err := json.Unmarshal(a, &va); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &vb); err != nil {
+			return nil, err
+		}
+	}
+
+	var changes []jsonChange
+	diffValues("", va, vb, &changes)
+	return changes, nil
+}
+
+func diffValues(path string, a, b any, out *[]jsonChange) {
+	switch va := a.(type) {
+	case map[string]any:
+		vb, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(va)+len(vb))
+		for k := range va {
+			keys = append(keys, k)
+		}
+		for k := range vb {
+			if _, exists := va[k]; !exists {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := path + "." + k
+
+			av, inA := va[k]
+			bv, inB := vb[k]
+			switch {
+			case !inA:
+				*out = append(*out, jsonChange{Op: "+", Path: p, New: bv})
+			case !inB:
+				*out = append(*out, jsonChange{Op: "-", Path: p, Old: av})
+			default:
+				diffValues(p, av, bv, out)
+			}
+		}
+		return
+	case []any:
+		vb, ok := b.([]any)
+		if !ok {
+			break
+		}
+
+		for i := 0; i < max(len(va), len(vb)); i += 1 {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(va):
+				*out = append(*out, jsonChange{Op: "+", Path: p, New: vb[i]})
+			case i >= len(vb):
+				*out = append(*out, jsonChange{Op: "-", Path: p, Old: va[i]})
+			default:
+				diffValues(p, va[i], vb[i], out)
+			}
+		}
+		return
+	}
+
+	if !jsonEqual(a, b) {
+		*out = append(*out, jsonChange{Op: "~", Path: path, Old: a, New: b})
+	}
+}
+
+func jsonEqual(a, b any) bool {
+	ba, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ba) == string(bb)
+}
+
+func compactJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return string(b)
+}
+
+func writeJSONChanges(w io.Writer, changes []jsonChange) error {
+	for _, c := range changes {
+		path := c.Path
+		if path == "" {
+			path = "."
+		}
+
+		var err error
+		switch c.Op {
+		case "+":
+			_, err = fmt.Fprintf(w, "+ %s: %s\n", path, compactJSON(c.New))
+		case "-":
+			_, err = fmt.Fprintf(w, "- %s: %s\n", path, compactJSON(c.Old))
+		default:
+			_, err = fmt.Fprintf(w, "~ %s: %s -> %s\n", path, compactJSON(c.Old), compactJSON(c.New))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// lockState locks the state file, and any directories that will be modified.
+// The returned function releases the locks.
+func lockState(ctx context.Context, cfg *configuration, command string, dirs ...string) (func(), error) {
+	return lockFiles(ctx, cfg.Configuration.Logger, command, cfg.StateFile, dirs...)
+}
+
+func lockFiles(ctx context.Context, log *slog.Logger, command string, statePath string, dirs ...string) (func(), error) {
+	var locks []*accords_mirrorrer.Lock
+
+	release := func() {
+		for _, l := range locks {
+			if err := l.Release(); err != nil {
+				log.With(slog.String("path", l.Path()), slog.Any("error", err)).ErrorContext(ctx, "error releasing lock")
+			}
+		}
+	}
+
+	l, err := accords_mirrorrer.LockState(statePath, command)
+	if err != nil {
+		return nil, err
+	}
+	locks = append(locks, l)
+
+	for _, dir := range dirs {
+		l, err := accords_mirrorrer.LockDir(dir, command)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+
+	return release, nil
+}
+
+// loadStateReadOnly loads the state for inspection, warning if another process is modifying it.
+func loadStateReadOnly(ctx context.Context, cfg *configuration) (*accords_mirrorrer.State, error) {
+	if holder, err := accords_mirrorrer.ReadLock(accords_mirrorrer.StateLockPath(cfg.StateFile)); err == nil && holder != nil {
+		cfg.Configuration.Logger.With(slog.String("holder", holder.String())).WarnContext(ctx, "state is being modified, showing the last saved version")
+	}
+
+	return accords_mirrorrer.LoadStateReadOnly(cfg.StateFile)
+}
+
+// openState loads the state, locking it and the directories first if it's going to be modified.
+// The returned function releases the locks.
+func openState(ctx context.Context, cfg *configuration, write bool, command string, dirs ...string) (*accords_mirrorrer.State, func(), error) {
+	if !write {
+		state, err := loadStateReadOnly(ctx, cfg)
+		return state, func() {}, err
+	}
+
+	release, err := lockState(ctx, cfg, command, dirs...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, err := accords_mirrorrer.LoadState(cfg.StateFile)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return state, release, nil
+}
+
+// unlockState forcibly removes the locks of the state file and directories.
+func unlockState(ctx context.Context, cfg *configuration, dirs []string) error {
+	log := cfg.Configuration.Logger
+
+	paths := []string{accords_mirrorrer.StateLockPath(cfg.StateFile)}
+	for _, dir := range dirs {
+		paths = append(paths, filepath.Join(dir, accords_mirrorrer.DirLockName))
+	}
+
+	var errs []error
+	for _, p := range paths {
+		holder, err := accords_mirrorrer.ReadLock(p)
+		if err != nil {
+			log.With(slog.String("path", p), slog.Any("error", err)).WarnContext(ctx, "unreadable lock file")
+		} else if holder == nil {
+			continue
+		}
+
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("error removing lock file: %w", err))
+			continue
+		}
+
+		l := log.With(slog.String("path", p))
+		if holder != nil {
+			l = l.With(slog.String("holder", holder.String()))
+		}
+		l.InfoContext(ctx, "removed lock")
+	}
+
+	return errors.Join(errs...)
+}
@@ -2,8 +2,8 @@ package state
 
 import (
 	"context"
-	"encoding/json"
 	"os"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 
@@ -22,6 +22,20 @@ func RegisterCommand(app *cli.App, globalCfg *config.Configuration) *cli.App {
 		StateFile:     "state.json",
 	}
 
+	showOpts := showOptions{}
+	diffFormat := "text"
+	mergeOpts := mergeOptions{OnConflict: conflictFail}
+	queryOpts := queryOptions{Format: "table"}
+	statsOpts := statsOptions{Format: "text", Depth: 3, Top: 10}
+	verifyOpts := verifyOptions{Root: "."}
+	recoverOpts := recoverOptions{Root: "."}
+	importOpts := importOptions{Root: ".", Mode: importModeLink}
+	gcOpts := gcOptions{Root: "."}
+	refsFormat := "text"
+	failuresOpts := failuresOptions{Format: "text"}
+	skippedOpts := skippedOptions{Format: "text", Profile: accords_mirrorrer.DefaultProfile}
+	unlockDirs := cli.StringSlice{}
+
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:  "state",
 		Usage: "state manipulation operations",
@@ -41,14 +55,371 @@ func RegisterCommand(app *cli.App, globalCfg *config.Configuration) *cli.App {
 					return exportState(context.Context, &cfg)
 				},
 			},
+			{
+				Name:      "history",
+				Usage:     "list the known versions of an entity, recorded when archive --refetch-after fetches it again",
+				ArgsUsage: "<kind> [slug]",
+				Action: func(context *cli.Context) error {
+					if context.NArg() < 1 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return entityHistory(context.Context, &cfg, context.Args().Get(0), context.Args().Get(1))
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "show an entity, optionally as it was at a given time",
+				ArgsUsage: "<kind> [slug]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "at",
+						Usage:       "show the version current at this time (RFC3339 or YYYY-MM-DD)",
+						Destination: &showOpts.At,
+					},
+					&cli.BoolFlag{
+						Name:        "diff",
+						Usage:       "print a diff from the selected version to the current one",
+						Destination: &showOpts.Diff,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					if context.NArg() < 1 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return entityShow(context.Context, &cfg, context.Args().Get(0), context.Args().Get(1), showOpts)
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "compare two state files",
+				ArgsUsage: "<a.json> <b.json>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "output format, text or json",
+						Value:       diffFormat,
+						Destination: &diffFormat,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					if context.NArg() != 2 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return diffStateFiles(context.Context, context.Args().Get(0), context.Args().Get(1), diffFormat)
+				},
+			},
+			{
+				Name:      "merge",
+				Usage:     "merge several state files",
+				ArgsUsage: "<state.json>...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "output",
+						Usage:       "write the merged state here instead of stdout",
+						Destination: &mergeOpts.Output,
+					},
+					&cli.StringFlag{
+						Name:        "on-conflict",
+						Usage:       "what to do if the sha256 or size of a download differs: fail, first, last, or reset",
+						Value:       mergeOpts.OnConflict,
+						Destination: &mergeOpts.OnConflict,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					if context.NArg() < 2 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return mergeStateFiles(context.Context, &cfg, context.Args().Slice(), mergeOpts)
+				},
+			},
+			{
+				Name:      "query",
+				Usage:     "query the entities or downloads",
+				ArgsUsage: "<kind> [where <expr>]",
+				Description: `Examples:
+   state query 'library where item.download_available and not downloads(url ~ "/scans/" and completed)'
+   state query --columns url,size 'downloads where not completed and size > 1000000'
+   state query --format jsonl --columns @slug,item.title 'library where hasContentScans'`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "columns",
+						Usage:       "comma-separated list of fields to output",
+						Destination: &queryOpts.Columns,
+					},
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "output format, table, json, or jsonl",
+						Value:       queryOpts.Format,
+						Destination: &queryOpts.Format,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					if context.NArg() < 1 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return runQuery(context.Context, &cfg, strings.Join(context.Args().Slice(), " "), queryOpts)
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "report entity counts and download progress",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "output format, text or json",
+						Value:       statsOpts.Format,
+						Destination: &statsOpts.Format,
+					},
+					&cli.IntFlag{
+						Name:        "depth",
+						Usage:       "number of path components to group downloads by",
+						Value:       statsOpts.Depth,
+						Destination: &statsOpts.Depth,
+					},
+					&cli.IntFlag{
+						Name:        "top",
+						Usage:       "number of the largest pending files to list",
+						Value:       statsOpts.Top,
+						Destination: &statsOpts.Top,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					return showStats(context.Context, &cfg, statsOpts)
+				},
+			},
+			{
+				Name:  "verify",
+				Usage: "rehash completed downloads and check them against the state",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "root",
+						Usage:       "directory the downloads were saved to",
+						Value:       verifyOpts.Root,
+						Destination: &verifyOpts.Root,
+					},
+					&cli.IntFlag{
+						Name:        "parallelism",
+						Usage:       "parallelism, <1 for GOMAXPROCS",
+						Value:       verifyOpts.Parallelism,
+						Destination: &verifyOpts.Parallelism,
+					},
+					&cli.IntFlag{
+						Name:        "sample",
+						Usage:       "only check this many randomly-chosen files, <1 for all",
+						Value:       verifyOpts.Sample,
+						Destination: &verifyOpts.Sample,
+					},
+					&cli.BoolFlag{
+						Name:        "reset",
+						Usage:       "unset the completion flag of bad files, so they're downloaded again",
+						Value:       verifyOpts.Reset,
+						Destination: &verifyOpts.Reset,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					return verifyState(context.Context, &cfg, verifyOpts)
+				},
+			},
+			{
+				Name:  "recover",
+				Usage: "rebuild the downloads from an existing download tree",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "root",
+						Usage:       "directory the downloads were saved to",
+						Value:       recoverOpts.Root,
+						Destination: &recoverOpts.Root,
+					},
+					&cli.IntFlag{
+						Name:        "parallelism",
+						Usage:       "parallelism, <1 for GOMAXPROCS",
+						Value:       recoverOpts.Parallelism,
+						Destination: &recoverOpts.Parallelism,
+					},
+					&cli.BoolFlag{
+						Name:        "fresh",
+						Usage:       "ignore the existing state file, e.g. if it's corrupted. Files can't be checked without it, so only pending downloads are rebuilt, and nothing is hashed",
+						Value:       recoverOpts.Fresh,
+						Destination: &recoverOpts.Fresh,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					return recoverState(context.Context, &cfg, recoverOpts)
+				},
+			},
+			{
+				Name:      "import-dir",
+				Usage:     "adopt files from another mirror for pending downloads",
+				ArgsUsage: "<dir>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "root",
+						Usage:       "directory the downloads are saved to",
+						Value:       importOpts.Root,
+						Destination: &importOpts.Root,
+					},
+					&cli.StringFlag{
+						Name:        "mode",
+						Usage:       "how to import files, link (falling back to copy) or copy",
+						Value:       importOpts.Mode,
+						Destination: &importOpts.Mode,
+					},
+					&cli.IntFlag{
+						Name:        "parallelism",
+						Usage:       "parallelism, <1 for GOMAXPROCS",
+						Value:       importOpts.Parallelism,
+						Destination: &importOpts.Parallelism,
+					},
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "only report what would be imported",
+						Value:       importOpts.DryRun,
+						Destination: &importOpts.DryRun,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					if context.NArg() != 1 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return importDir(context.Context, &cfg, context.Args().Get(0), importOpts)
+				},
+			},
+			{
+				Name:  "gc",
+				Usage: "find downloads no longer referenced by any entity, and files not in the state",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "root",
+						Usage:       "directory the downloads are saved to",
+						Value:       gcOpts.Root,
+						Destination: &gcOpts.Root,
+					},
+					&cli.BoolFlag{
+						Name:        "delete",
+						Usage:       "delete the files and remove the downloads from the state",
+						Value:       gcOpts.Delete,
+						Destination: &gcOpts.Delete,
+					},
+					&cli.StringFlag{
+						Name:        "quarantine",
+						Usage:       "move the files to this directory and remove the downloads from the state",
+						Destination: &gcOpts.Quarantine,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					return collectGarbage(context.Context, &cfg, gcOpts)
+				},
+			},
+			{
+				Name:      "who-uses",
+				Usage:     "list the entities referencing a download",
+				ArgsUsage: "<url>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "output format, text or json",
+						Value:       refsFormat,
+						Destination: &refsFormat,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					if context.NArg() != 1 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return whoUses(context.Context, &cfg, context.Args().Get(0), refsFormat)
+				},
+			},
+			{
+				Name:      "assets",
+				Usage:     "list the downloads referenced by an entity",
+				ArgsUsage: "<kind> <slug>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "output format, text or json",
+						Value:       refsFormat,
+						Destination: &refsFormat,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					if context.NArg() != 2 {
+						return cli.ShowSubcommandHelp(context)
+					}
+
+					return entityAssets(context.Context, &cfg, context.Args().Get(0), context.Args().Get(1), refsFormat)
+				},
+			},
+			{
+				Name:  "failures",
+				Usage: "list the downloads that have failed, and why",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "output format, text or json",
+						Value:       failuresOpts.Format,
+						Destination: &failuresOpts.Format,
+					},
+					&cli.StringFlag{
+						Name:        "status",
+						Usage:       "only list downloads with this status, failed or gone",
+						Value:       failuresOpts.Status,
+						Destination: &failuresOpts.Status,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					return listFailures(context.Context, &cfg, failuresOpts)
+				},
+			},
+			{
+				Name:  "skipped",
+				Usage: "list the pending downloads that a download profile skips, and why",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Usage:       "output format, text or json",
+						Value:       skippedOpts.Format,
+						Destination: &skippedOpts.Format,
+					},
+					&cli.StringFlag{
+						Name:        "profile",
+						Usage:       "name of the saved download filters",
+						Value:       skippedOpts.Profile,
+						Destination: &skippedOpts.Profile,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					return listSkipped(context.Context, &cfg, skippedOpts)
+				},
+			},
+			{
+				Name:  "unlock",
+				Usage: "forcibly remove the lock of the state file, after a crash on another host",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:        "dir",
+						Usage:       "also unlock this output directory",
+						Destination: &unlockDirs,
+					},
+				},
+				Action: func(context *cli.Context) error {
+					return unlockState(context.Context, &cfg, unlockDirs.Value())
+				},
+			},
 		},
 	})
 
 	return app
 }
 
-func exportState(_ context.Context, cfg *configuration) error {
-	state, err := accords_mirrorrer.LoadState(cfg.StateFile)
+func exportState(ctx context.Context, cfg *configuration) error {
+	state, err := loadStateReadOnly(ctx, cfg)
 	if err != nil {
 		return err
 	}
@@ -57,11 +428,5 @@ func exportState(_ context.Context, cfg *configuration) error {
 		di.Completed = false
 	}
 
-	b, err := json.MarshalIndent(state, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	_, err = os.Stdout.Write(b)
-	return err
+	return accords_mirrorrer.WriteState(os.Stdout, state)
 }
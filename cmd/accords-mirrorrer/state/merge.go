@@ -0,0 +1,258 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+const (
+	conflictFail  = "fail"
+	conflictFirst = "first"
+	conflictLast  = "last"
+	conflictReset = "reset"
+)
+
+type mergeOptions struct {
+	Output     string
+	OnConflict string
+}
+
+// entityUpdatedAt finds an "updatedAt" attribute at the top level of the entity, or one level down.
+func entityUpdatedAt(raw json.RawMessage) time.Time {
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return time.Time{}
+	}
+
+	parse := func(v any) time.Time {
+		s, _ := v.(string)
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	}
+
+	if t := parse(doc["updatedAt"]); !t.IsZero() {
+		return t
+	}
+
+	for _, v := range doc {
+		if m, ok := v.(map[string]any); ok {
+			if t := parse(m["updatedAt"]); !t.IsZero() {
+				return t
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// isNewer reports whether b should replace a.
+func isNewer(a, b *accords_mirrorrer.RawEntity) bool {
+	if !a.FetchedAt.Equal(b.FetchedAt) {
+		return b.FetchedAt.After(a.FetchedAt)
+	}
+
+	return entityUpdatedAt(b.Data).After(entityUpdatedAt(a.Data))
+}
+
+func mergeEntity(a, b *accords_mirrorrer.RawEntity) *accords_mirrorrer.RawEntity {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case isNewer(a, b):
+		return accords_mirrorrer.UpdateRawEntity(a, b)
+	default:
+		return accords_mirrorrer.UpdateRawEntity(b, a)
+	}
+}
+
+func mergeEntityMap(dst, src map[string]*accords_mirrorrer.RawEntity) {
+	for slug, e := range src {
+		dst[slug] = mergeEntity(dst[slug], e)
+	}
+}
+
+func mergeEntities(dst, src *accords_mirrorrer.State) {
+	dstRaw := dst.RawEntities()
+	for kind, entities := range src.RawEntities() {
+		mergeEntityMap(dstRaw[kind], entities)
+	}
+}
+
+// mergeProfiles adds the profiles of src that dst doesn't have.
+func mergeProfiles(dst, src *accords_mirrorrer.State) {
+	for name, p := range src.Profiles {
+		if _, exists := dst.Profiles[name]; exists {
+			continue
+		}
+
+		if dst.Profiles == nil {
+			dst.Profiles = map[string]*accords_mirrorrer.Profile{}
+		}
+		dst.Profiles[name] = p
+	}
+}
+
+// mergeScraped adds the scraped URLs of src to those of dst, so gc sees everything either scrape found.
+func mergeScraped(dst, src *accords_mirrorrer.State) {
+	if src.Scraped == nil {
+		return
+	}
+
+	dst.Scraped = append(dst.Scraped, src.Scraped...)
+	slices.Sort(dst.Scraped)
+	dst.Scraped = slices.Compact(dst.Scraped)
+}
+
+func downloadsConflict(a, b *accords_mirrorrer.Download) bool {
+	if a.SHA256 != "" && b.SHA256 != "" && a.SHA256 != b.SHA256 {
+		return true
+	}
+
+	return a.Size > 0 && b.Size > 0 && a.Size != b.Size
+}
+
+func mergeDownloads(dst, src map[string]*accords_mirrorrer.Download, policy string, log *slog.Logger) error {
+	var errs []error
+
+	for u, b := range src {
+		a, exists := dst[u]
+		if !exists {
+			dst[u] = b
+			continue
+		}
+
+		if downloadsConflict(a, b) {
+			l := log.With(
+				slog.String("url", u),
+				slog.String("sha256_a", a.SHA256),
+				slog.String("sha256_b", b.SHA256),
+				slog.Int64("size_a", a.Size),
+				slog.Int64("size_b", b.Size),
+				slog.String("policy", policy),
+			)
+			l.Warn("download conflict")
+
+			switch policy {
+			case conflictFirst:
+			case conflictLast:
+				dst[u] = b
+			case conflictReset:
+				a.SHA256 = ""
+				a.Size = 0
+				a.Completed = false
+			default:
+				errs = append(errs, fmt.Errorf("conflicting download: %v", u))
+			}
+
+			continue
+		}
+
+		if a.SHA256 == "" {
+			a.SHA256 = b.SHA256
+		}
+
+		if a.Size == 0 {
+			a.Size = b.Size
+		}
+
+		if a.ContentLength == 0 {
+			a.ContentLength = b.ContentLength
+		}
+
+		if a.ETag == "" && a.LastModified == "" {
+			a.ETag = b.ETag
+			a.LastModified = b.LastModified
+		}
+
+		a.Completed = a.Completed || b.Completed
+
+		// Keep the most recent failure, unless either succeeded.
+		switch {
+		case a.Completed:
+			a.ClearFailures()
+		case b.LastAttempt != nil && (a.LastAttempt == nil || b.LastAttempt.After(*a.LastAttempt)):
+			a.Attempts = b.Attempts
+			a.LastError = b.LastError
+			a.LastStatus = b.LastStatus
+			a.LastAttempt = b.LastAttempt
+		}
+
+		for _, ref := range b.Refs {
+			a.AddRef(ref)
+		}
+
+		for _, v := range b.Versions {
+			if !slices.ContainsFunc(a.Versions, func(av accords_mirrorrer.FileVersion) bool { return av.OutPath == v.OutPath }) {
+				a.Versions = append(a.Versions, v)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func mergeStateFiles(ctx context.Context, cfg *configuration, paths []string, opts mergeOptions) error {
+	switch opts.OnConflict {
+	case conflictFail, conflictFirst, conflictLast, conflictReset:
+	default:
+		return fmt.Errorf("unknown conflict policy: %v", opts.OnConflict)
+	}
+
+	// A missing state would load as an empty one.
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return err
+		}
+	}
+
+	log := cfg.Configuration.Logger
+
+	if opts.Output != "" {
+		release, err := lockFiles(ctx, log, "state merge", opts.Output)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	var merged *accords_mirrorrer.State
+	for _, p := range paths {
+		state, err := accords_mirrorrer.LoadState(p)
+		if err != nil {
+			return fmt.Errorf("error loading %v: %w", p, err)
+		}
+
+		if merged == nil {
+			merged = state
+			continue
+		}
+
+		mergeEntities(merged, state)
+		mergeProfiles(merged, state)
+		mergeScraped(merged, state)
+
+		for _, kind := range merged.MergeExtra(state) {
+			log.With(slog.String("file", p), slog.String("kind", kind)).WarnContext(ctx, "unknown kind differs between the states, keeping the first")
+		}
+
+		if err := mergeDownloads(merged.Downloads, state.Downloads, opts.OnConflict, log.With(slog.String("file", p))); err != nil {
+			return err
+		}
+	}
+
+	if opts.Output != "" {
+		return accords_mirrorrer.SaveState(merged, opts.Output)
+	}
+
+	return accords_mirrorrer.WriteState(os.Stdout, merged)
+}
@@ -0,0 +1,111 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// saveStateWith saves the state with extra top-level values, as a newer version might write.
+func saveStateWith(t *testing.T, state *accords_mirrorrer.State, path string, extra map[string]string) {
+	t.Helper()
+
+	if err := accords_mirrorrer.SaveState(state, path); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range extra {
+		doc[k] = json.RawMessage(v)
+	}
+
+	if b, err = json.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeStateFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfiguration(dir)
+	pathA, pathB := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	out := filepath.Join(dir, "merged.json")
+
+	a := accords_mirrorrer.NewState()
+	_, _ = a.AddDownload("https://a.example.com/a.pdf")
+	a.Scraped = []string{"https://a.example.com/a.pdf"}
+	saveStateWith(t, a, pathA, map[string]string{"future": `{"n":1}`})
+
+	b := accords_mirrorrer.NewState()
+	_, _ = b.AddDownload("https://a.example.com/b.pdf")
+	b.Scraped = []string{"https://a.example.com/b.pdf"}
+	saveStateWith(t, b, pathB, map[string]string{"future": `{"n":2}`, "other": `[1]`})
+
+	if err := mergeStateFiles(context.Background(), cfg, []string{pathA, pathB}, mergeOptions{Output: out, OnConflict: conflictFail}); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := accords_mirrorrer.LoadState(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(merged.Downloads) != 2 || len(merged.Scraped) != 2 {
+		t.Errorf("%v downloads and %v scraped urls, want 2 of each", len(merged.Downloads), len(merged.Scraped))
+	}
+
+	raw, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := compactJSON(doc["future"]); got != `{"n":1}` {
+		t.Errorf("future = %v, want the first state's", got)
+	}
+
+	if got := compactJSON(doc["other"]); got != `[1]` {
+		t.Errorf("other = %v, want the second state's", got)
+	}
+}
+
+func TestMergeStateFilesMissing(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfiguration(dir)
+	existing := filepath.Join(dir, "state.json")
+	out := filepath.Join(dir, "merged.json")
+
+	if err := accords_mirrorrer.SaveState(accords_mirrorrer.NewState(), existing); err != nil {
+		t.Fatal(err)
+	}
+
+	err := mergeStateFiles(context.Background(), cfg, []string{existing, filepath.Join(dir, "missing.json")}, mergeOptions{Output: out, OnConflict: conflictFail})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+
+	if _, err := os.Stat(out); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("merged state was written: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+type queryOptions struct {
+	Columns string
+	Format  string
+}
+
+type entityRecord struct {
+	kind  string
+	slug  string
+	raw   *accords_mirrorrer.RawEntity
+	index map[entityKey][]*accords_mirrorrer.Download
+
+	doc       any
+	decoded   bool
+	downloads []record
+}
+
+func (r *entityRecord) Field(path []string) (any, bool) {
+	if len(path) == 1 {
+		switch path[0] {
+		case "@kind":
+			return r.kind, true
+		case "@slug":
+			return r.slug, true
+		case "@fetched_at":
+			return formatFetchedAt(r.raw.FetchedAt), true
+		case "@sha256":
+			return r.raw.SHA256, true
+		case "@source":
+			return r.raw.Source, true
+		}
+	}
+
+	if !r.decoded {
+		_ = json.Unmarshal(r.raw.Data, &r.doc)
+		r.decoded = true
+	}
+
+	return lookupPath(r.doc, path)
+}
+
+func (r *entityRecord) Downloads() []record {
+	if r.downloads == nil {
+		r.downloads = []record{}
+		for _, di := range r.index[entityKey{Kind: r.kind, Slug: r.slug}] {
+			r.downloads = append(r.downloads, downloadRecord{di})
+		}
+	}
+
+	return r.downloads
+}
+
+type downloadRecord struct {
+	di *accords_mirrorrer.Download
+}
+
+func (r downloadRecord) Field(path []string) (any, bool) {
+	if len(path) != 1 {
+		return nil, false
+	}
+
+	switch path[0] {
+	case "url":
+		return r.di.URL, true
+	case "out_path":
+		return r.di.OutPath, true
+	case "size":
+		return float64(r.di.Size), true
+	case "content_length":
+		return float64(r.di.ContentLength), true
+	case "sha256":
+		return r.di.SHA256, true
+	case "completed":
+		return r.di.Completed, true
+	case "status":
+		return r.di.Status(), true
+	case "attempts":
+		return float64(r.di.Attempts), true
+	case "last_status":
+		return float64(r.di.LastStatus), true
+	case "last_error":
+		return r.di.LastError, true
+	case "etag":
+		return r.di.ETag, true
+	case "last_modified":
+		return r.di.LastModified, true
+	case "versions":
+		return float64(len(r.di.Versions)), true
+	case "role", "kind", "slug", "lang":
+		out := fanout{}
+		for _, ref := range r.di.Refs {
+			switch path[0] {
+			case "role":
+				out = append(out, ref.Role)
+			case "kind":
+				out = append(out, ref.Kind)
+			case "slug":
+				out = append(out, ref.Slug)
+			case "lang":
+				out = append(out, ref.Lang)
+			}
+		}
+		return out, len(out) > 0
+	case "host", "path":
+		u, err := url.Parse(r.di.URL)
+		if err != nil {
+			return nil, false
+		}
+
+		if path[0] == "host" {
+			return u.Host, true
+		}
+
+		return u.Path, true
+	}
+
+	return nil, false
+}
+
+func (r downloadRecord) Downloads() []record {
+	return nil
+}
+
+func queryRecords(state *accords_mirrorrer.State, kind string) ([]record, error) {
+	if kind == "downloads" {
+		records := make([]record, 0, len(state.Downloads))
+		for _, u := range sortedKeys(state.Downloads) {
+			records = append(records, downloadRecord{state.Downloads[u]})
+		}
+
+		return records, nil
+	}
+
+	entities, exists := state.RawEntities()[kind]
+	if !exists {
+		return nil, fmt.Errorf("unknown kind %q, expected downloads or one of: %s", kind, strings.Join(entityKinds(state), ", "))
+	}
+
+	index := indexRefs(state)
+
+	records := make([]record, 0, len(entities))
+	for _, slug := range sortedKeys(entities) {
+		if e := entities[slug]; e != nil {
+			records = append(records, &entityRecord{kind: kind, slug: slug, raw: e, index: index})
+		}
+	}
+
+	return records, nil
+}
+
+func jsonValue(v any) any {
+	if vs, ok := v.(fanout); ok {
+		out := make([]any, len(vs))
+		for i, vv := range vs {
+			out[i] = jsonValue(vv)
+		}
+		return out
+	}
+
+	return v
+}
+
+func textValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	return compactJSON(jsonValue(v))
+}
+
+func runQuery(ctx context.Context, cfg *configuration, q string, opts queryOptions) error {
+	parsed, err := parseQuery(q)
+	if err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+
+	state, err := loadStateWithRefs(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := queryRecords(state, parsed.Kind)
+	if err != nil {
+		return err
+	}
+
+	columns := strings.Split(opts.Columns, ",")
+	if opts.Columns == "" {
+		if parsed.Kind == "downloads" {
+			columns = []string{"url", "size", "completed"}
+		} else {
+			columns = []string{"@kind", "@slug", "@fetched_at"}
+		}
+	}
+
+	var w *tabwriter.Writer
+	enc := json.NewEncoder(os.Stdout)
+
+	var rows []map[string]any
+
+	switch opts.Format {
+	case "table":
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+	case "json":
+		rows = []map[string]any{}
+		enc.SetIndent("", "  ")
+	case "jsonl":
+	default:
+		return fmt.Errorf("unknown format: %v", opts.Format)
+	}
+
+	for _, r := range records {
+		if parsed.Where != nil && !parsed.Where.eval(r) {
+			continue
+		}
+
+		values := make([]any, len(columns))
+		for i, c := range columns {
+			values[i], _ = r.Field(strings.Split(c, "."))
+		}
+
+		if w != nil {
+			cells := make([]string, len(values))
+			for i, v := range values {
+				cells[i] = textValue(v)
+			}
+
+			_, _ = fmt.Fprintln(w, strings.Join(cells, "\t"))
+			continue
+		}
+
+		row := make(map[string]any, len(columns))
+		for i, c := range columns {
+			row[c] = jsonValue(values[i])
+		}
+
+		if rows != nil {
+			rows = append(rows, row)
+		} else if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	if w != nil {
+		return w.Flush()
+	}
+
+	if rows != nil {
+		return enc.Encode(rows)
+	}
+
+	return nil
+}
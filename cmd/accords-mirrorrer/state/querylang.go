@@ -0,0 +1,551 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The query language looks like:
+//
+//	library where item.download_available = true and not downloads(url ~ "/scans/" and completed)
+//
+//	query   := kind [ "where" expr ]
+//	expr    := and { "or" and }
+//	and     := unary { "and" unary }
+//	unary   := "not" unary | primary
+//	primary := "(" expr ")" | "downloads" "(" [ expr ] ")" | path [ op value ]
+//	op      := "=" | "!=" | "<" | "<=" | ">" | ">=" | "~"
+//	value   := string | number | "true" | "false" | "null"
+//
+// Paths are dot-separated. Traversing an array without an index matches any element.
+
+type record interface {
+	Field(path []string) (any, bool)
+	Downloads() []record
+}
+
+type queryExpr interface {
+	eval(r record) bool
+}
+
+type query struct {
+	Kind  string
+	Where queryExpr
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	Kind  tokenKind
+	Value string
+	Pos   int
+}
+
+func isIdentRune(r rune, first bool) bool {
+	if unicode.IsLetter(r) || r == '_' || r == '@' {
+		return true
+	}
+
+	return !first && (unicode.IsDigit(r) || r == '.')
+}
+
+func lexQuery(s string) ([]token, error) {
+	var tokens []token
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i += 1
+		case r == '(':
+			tokens = append(tokens, token{Kind: tokLParen, Value: "(", Pos: i})
+			i += 1
+		case r == ')':
+			tokens = append(tokens, token{Kind: tokRParen, Value: ")", Pos: i})
+			i += 1
+		case r == '"' || r == '\'':
+			j := i + 1
+			for ; j < len(rs) && rs[j] != r; j += 1 {
+				if rs[j] == '\\' {
+					j += 1
+				}
+			}
+
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+
+			raw := string(rs[i+1 : j])
+			if r == '"' {
+				var err error
+				if raw, err = strconv.Unquote(`"` + raw + `"`); err != nil {
+					return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+				}
+			}
+
+			tokens = append(tokens, token{Kind: tokString, Value: raw, Pos: i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j += 1
+			}
+
+			tokens = append(tokens, token{Kind: tokNumber, Value: string(rs[i:j]), Pos: i})
+			i = j
+		case strings.ContainsRune("=!<>~", r):
+			j := i + 1
+			if j < len(rs) && rs[j] == '=' {
+				j += 1
+			}
+
+			op := string(rs[i:j])
+			switch op {
+			case "=", "!=", "<", "<=", ">", ">=", "~":
+			default:
+				return nil, fmt.Errorf("invalid operator %q at %d", op, i)
+			}
+
+			tokens = append(tokens, token{Kind: tokOp, Value: op, Pos: i})
+			i = j
+		case isIdentRune(r, true):
+			j := i + 1
+			for j < len(rs) && isIdentRune(rs[j], false) {
+				j += 1
+			}
+
+			tokens = append(tokens, token{Kind: tokIdent, Value: string(rs[i:j]), Pos: i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", r, i)
+		}
+	}
+
+	return append(tokens, token{Kind: tokEOF, Pos: len(rs)}), nil
+}
+
+type queryParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.Kind != tokEOF {
+		p.pos += 1
+	}
+
+	return t
+}
+
+func (p *queryParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.Kind == tokIdent && strings.EqualFold(t.Value, kw) {
+		p.pos += 1
+		return true
+	}
+
+	return false
+}
+
+func (p *queryParser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.Kind != kind {
+		return t, fmt.Errorf("expected %v at %d", what, t.Pos)
+	}
+
+	return t, nil
+}
+
+func parseQuery(s string) (*query, error) {
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+
+	kind, err := p.expect(tokIdent, "kind")
+	if err != nil {
+		return nil, err
+	}
+
+	q := &query{Kind: kind.Value}
+
+	if p.keyword("where") {
+		if q.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if t := p.peek(); t.Kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.Value, t.Pos)
+	}
+
+	return q, nil
+}
+
+func (p *queryParser) parseOr() (queryExpr, error) {
+	lhs, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		rhs, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = orExpr{lhs, rhs}
+	}
+
+	return lhs, nil
+}
+
+func (p *queryParser) parseAnd() (queryExpr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = andExpr{lhs, rhs}
+	}
+
+	return lhs, nil
+}
+
+func (p *queryParser) parseUnary() (queryExpr, error) {
+	if p.keyword("not") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notExpr{e}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryExpr, error) {
+	t := p.next()
+
+	switch t.Kind {
+	case tokLParen:
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	case tokIdent:
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", t.Value, t.Pos)
+	}
+
+	if strings.EqualFold(t.Value, "downloads") && p.peek().Kind == tokLParen {
+		p.next()
+
+		je := joinExpr{}
+		if p.peek().Kind != tokRParen {
+			var err error
+			if je.Where, err = p.parseOr(); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+
+		return je, nil
+	}
+
+	path := strings.Split(t.Value, ".")
+
+	if p.peek().Kind != tokOp {
+		return truthyExpr{Path: path}, nil
+	}
+
+	op := p.next().Value
+
+	v := p.next()
+
+	var value any
+	switch v.Kind {
+	case tokString:
+		value = v.Value
+	case tokNumber:
+		f, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number at %d: %w", v.Pos, err)
+		}
+		value = f
+	case tokIdent:
+		switch strings.ToLower(v.Value) {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			return nil, fmt.Errorf("expected value at %d", v.Pos)
+		}
+	default:
+		return nil, fmt.Errorf("expected value at %d", v.Pos)
+	}
+
+	ce := compareExpr{Path: path, Op: op, Value: value}
+
+	if op == "~" {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected regex string at %d", v.Pos)
+		}
+
+		var err error
+		if ce.Regexp, err = regexp.Compile(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return ce, nil
+}
+
+type orExpr struct{ A, B queryExpr }
+
+func (e orExpr) eval(r record) bool { return e.A.eval(r) || e.B.eval(r) }
+
+type andExpr struct{ A, B queryExpr }
+
+func (e andExpr) eval(r record) bool { return e.A.eval(r) && e.B.eval(r) }
+
+type notExpr struct{ E queryExpr }
+
+func (e notExpr) eval(r record) bool { return !e.E.eval(r) }
+
+type joinExpr struct{ Where queryExpr }
+
+func (e joinExpr) eval(r record) bool {
+	for _, d := range r.Downloads() {
+		if e.Where == nil || e.Where.eval(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// anyValue calls fn for the value, or each of its elements if it's a fanned-out array.
+func anyValue(v any, fn func(v any) bool) bool {
+	if vs, ok := v.(fanout); ok {
+		for _, vv := range vs {
+			if anyValue(vv, fn) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return fn(v)
+}
+
+type truthyExpr struct{ Path []string }
+
+func (e truthyExpr) eval(r record) bool {
+	v, ok := r.Field(e.Path)
+	if !ok {
+		return false
+	}
+
+	return anyValue(v, func(v any) bool {
+		switch vv := v.(type) {
+		case nil:
+			return false
+		case bool:
+			return vv
+		case string:
+			return vv != ""
+		case float64:
+			return vv != 0
+		case []any:
+			return len(vv) > 0
+		case map[string]any:
+			return len(vv) > 0
+		default:
+			return true
+		}
+	})
+}
+
+type compareExpr struct {
+	Path   []string
+	Op     string
+	Value  any
+	Regexp *regexp.Regexp
+}
+
+func (e compareExpr) eval(r record) bool {
+	v, ok := r.Field(e.Path)
+	if !ok {
+		v = nil
+	}
+
+	return anyValue(v, func(v any) bool { return e.compare(v) })
+}
+
+func (e compareExpr) compare(v any) bool {
+	if e.Op == "~" {
+		s, ok := v.(string)
+		if !ok {
+			s = compactJSON(v)
+		}
+
+		return e.Regexp.MatchString(s)
+	}
+
+	var c int
+	switch want := e.Value.(type) {
+	case float64:
+		got, ok := toFloat(v)
+		if !ok {
+			return e.Op == "!="
+		}
+
+		switch {
+		case got < want:
+			c = -1
+		case got > want:
+			c = 1
+		}
+	case string:
+		got, ok := v.(string)
+		if !ok {
+			return e.Op == "!="
+		}
+
+		c = strings.Compare(got, want)
+	default:
+		eq := jsonEqual(v, want)
+		switch e.Op {
+		case "=":
+			return eq
+		case "!=":
+			return !eq
+		default:
+			return false
+		}
+	}
+
+	switch e.Op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch vv := v.(type) {
+	case float64:
+		return vv, true
+	case int64:
+		return float64(vv), true
+	case int:
+		return float64(vv), true
+	case string:
+		f, err := strconv.ParseFloat(vv, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// fanout is the result of traversing an array without an index.
+type fanout []any
+
+// lookupPath walks a decoded JSON document.
+func lookupPath(v any, path []string) (any, bool) {
+	for i, p := range path {
+		switch vv := v.(type) {
+		case map[string]any:
+			next, exists := vv[p]
+			if !exists {
+				return nil, false
+			}
+			v = next
+		case []any:
+			if idx, err := strconv.Atoi(p); err == nil {
+				if idx < 0 || idx >= len(vv) {
+					return nil, false
+				}
+				v = vv[idx]
+				continue
+			}
+
+			out := make(fanout, 0, len(vv))
+			for _, elem := range vv {
+				if found, ok := lookupPath(elem, path[i:]); ok {
+					out = append(out, found)
+				}
+			}
+
+			return out, len(out) > 0
+		case fanout:
+			out := make(fanout, 0, len(vv))
+			for _, elem := range vv {
+				if found, ok := lookupPath(elem, path[i:]); ok {
+					out = append(out, found)
+				}
+			}
+
+			return out, len(out) > 0
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
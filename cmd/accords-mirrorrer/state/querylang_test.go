@@ -0,0 +1,165 @@
+package state
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testRecord is a record backed by a decoded JSON document.
+type testRecord struct {
+	doc       any
+	downloads []record
+}
+
+func (r testRecord) Field(path []string) (any, bool) {
+	return lookupPath(r.doc, path)
+}
+
+func (r testRecord) Downloads() []record {
+	return r.downloads
+}
+
+func decodeRecord(t *testing.T, doc string, downloads ...record) testRecord {
+	t.Helper()
+
+	var v any
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	return testRecord{doc: v, downloads: downloads}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		kind  string
+		where bool
+		err   string
+	}{
+		{query: "library", kind: "library"},
+		{query: "library where slug", kind: "library", where: true},
+		{query: "library WHERE a = 1 and not (b or c)", kind: "library", where: true},
+		{query: "content where a >= -1.5 or b != null", kind: "content", where: true},
+		{query: `library where a = 'single \quoted'`, kind: "library", where: true},
+		{query: "library where downloads()", kind: "library", where: true},
+		{query: `library where downloads(url ~ "/scans/" and completed)`, kind: "library", where: true},
+
+		{query: "", err: "expected kind at 0"},
+		{query: "library where", err: "unexpected"},
+		{query: "library slug", err: `unexpected "slug" at 8`},
+		{query: "library where a =", err: "expected value at 17"},
+		{query: "library where a = foo", err: "expected value at 18"},
+		{query: "library where a == 1", err: `invalid operator "==" at 16`},
+		{query: "library where a ! 1", err: `invalid operator "!" at 16`},
+		{query: "library where (a", err: "expected ) at 16"},
+		{query: "library where downloads(a", err: "expected ) at 25"},
+		{query: "library where a ~ 1", err: "expected regex string at 18"},
+		{query: `library where a ~ "("`, err: "error parsing regexp"},
+		{query: `library where a = "x`, err: "unterminated string at 18"},
+		{query: `library where a = "\q"`, err: "invalid string at 18"},
+		{query: "library where a = 1.2.3", err: "invalid number at 18"},
+		{query: "library where a $ 1", err: `unexpected '$' at 16`},
+	}
+
+	for _, tt := range tests {
+		q, err := parseQuery(tt.query)
+
+		switch {
+		case tt.err != "":
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.query)
+			} else if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %q, want %q", tt.query, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error: %v", tt.query, err)
+		case q.Kind != tt.kind:
+			t.Errorf("%q: kind = %q, want %q", tt.query, q.Kind, tt.kind)
+		case (q.Where != nil) != tt.where:
+			t.Errorf("%q: where = %v, want %v", tt.query, q.Where != nil, tt.where)
+		}
+	}
+}
+
+func TestEvalQuery(t *testing.T) {
+	r := decodeRecord(t, `{
+		"slug": "some-item",
+		"n": 3,
+		"ok": true,
+		"empty": "",
+		"nil": null,
+		"tags": ["x", "y"],
+		"items": [{"n": 1}, {"n": 5}],
+		"nested": {"k": "v"}
+	}`,
+		decodeRecord(t, `{"url": "https://example.com/scans/1.zip", "completed": true, "size": 10}`),
+		decodeRecord(t, `{"url": "https://example.com/audio/1.mp3", "completed": false}`),
+	)
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{"ok", true},
+		{"empty", false},
+		{"nil", false},
+		{"missing", false},
+		{"nested", true},
+
+		{"n = 3", true},
+		{"n != 3", false},
+		{"n < 3", false},
+		{"n <= 3", true},
+		{"n > 2.5", true},
+		{"n >= 4", false},
+		{`n = "3"`, false},
+		{`n != "3"`, true},
+		{`slug = "some-item"`, true},
+		{`slug < "t"`, true},
+		{"slug > 1", false},
+		{"ok = true", true},
+		{"ok = false", false},
+		{"nil = null", true},
+		{"missing = null", true},
+		{"slug = null", false},
+
+		{`slug ~ "^some-"`, true},
+		{`slug ~ "^item"`, false},
+		{`nested ~ "\"k\":\"v\""`, true},
+
+		{`tags ~ "\"y\""`, true},
+		{`tags ~ "z"`, false},
+		{"items.n > 4", true},
+		{"items.n > 5", false},
+		{"items.0.n > 4", false},
+		{"items.1.n = 5", true},
+		{"items.2.n = 5", false},
+
+		{"ok and n = 3", true},
+		{"ok and n = 4", false},
+		{"not ok or n = 3", true},
+		{"not (ok and n = 4)", true},
+		{"empty or missing or n = 4", false},
+		{"ok or n = 4 and empty", true},
+
+		{"downloads()", true},
+		{`downloads(url ~ "/scans/" and completed)`, true},
+		{`downloads(url ~ "/audio/" and completed)`, false},
+		{"downloads(size > 5)", true},
+		{"not downloads(missing)", true},
+	}
+
+	for _, tt := range tests {
+		q, err := parseQuery("library where " + tt.where)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.where, err)
+			continue
+		}
+
+		if got := q.Where.eval(r); got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.where, got, tt.want)
+		}
+	}
+}
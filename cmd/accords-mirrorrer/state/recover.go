@@ -0,0 +1,278 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.vs49688.net/zane/goutils/parallel"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+	"git.vs49688.net/zane/accords-mirrorrer/mirror"
+)
+
+type recoverOptions struct {
+	Root        string
+	Parallelism int
+	Fresh       bool
+}
+
+type localFile struct {
+	// Rel is the slash-separated path relative to the root.
+	Rel  string
+	Path string
+
+	Size   int64
+	SHA256 string
+}
+
+// walkFiles lists the regular files under root, skipping the given files and directories.
+func walkFiles(root string, skip ...string) ([]*localFile, error) {
+	skipped := make(map[string]struct{}, len(skip))
+	for _, p := range skip {
+		if abs, err := filepath.Abs(p); err == nil {
+			skipped[abs] = struct{}{}
+		}
+	}
+
+	var files []*localFile
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if abs, err := filepath.Abs(p); err == nil {
+			if _, exists := skipped[abs]; exists && d.IsDir() {
+				return filepath.SkipDir
+			} else if exists {
+				return nil
+			}
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &localFile{Rel: filepath.ToSlash(rel), Path: p})
+		return nil
+	})
+
+	return files, err
+}
+
+func hashFiles(ctx context.Context, files []*localFile, parallelism int) []error {
+	return parallel.Parallel(ctx, parallelism, files, func(ctx context.Context, f *localFile) error {
+		var err error
+		f.Size, f.SHA256, err = hashFile(f.Path)
+		return err
+	})
+}
+
+// urlFromOutPath is the inverse of the path.Join(u.Host, u.Path) done by State.AddDownload.
+func urlFromOutPath(outPath string) (string, bool) {
+	host, p, found := strings.Cut(outPath, "/")
+	if !found || !strings.Contains(host, ".") || strings.HasPrefix(host, ".") {
+		return "", false
+	}
+
+	u := url.URL{Scheme: "https", Host: host, Path: path.Join("/", p)}
+	return u.String(), true
+}
+
+// adoptFile checks a local file against a download, returning true if it's a match.
+func adoptFile(di *accords_mirrorrer.Download, f *localFile) bool {
+	switch {
+	case di.SHA256 != "":
+		if di.SHA256 != f.SHA256 {
+			return false
+		}
+	case di.Size > 0:
+		if di.Size != f.Size {
+			return false
+		}
+	case di.ContentLength > 0:
+		if di.ContentLength != f.Size {
+			return false
+		}
+	}
+
+	di.Size = f.Size
+	di.SHA256 = f.SHA256
+	di.Completed = true
+	return true
+}
+
+// recoverState rebuilds the downloads from the files under the root, completing those that match
+// the state. Without a state to check against, as with --fresh, it only rebuilds pending downloads.
+func recoverState(ctx context.Context, cfg *configuration, opts recoverOptions) error {
+	log := cfg.Configuration.Logger
+
+	release, err := lockState(ctx, cfg, "state recover")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	state := accords_mirrorrer.NewState()
+	if !opts.Fresh {
+		if state, err = accords_mirrorrer.LoadState(cfg.StateFile); err != nil {
+			return fmt.Errorf("unable to load state, use --fresh to start over: %w", err)
+		}
+	}
+
+	files, err := walkFiles(opts.Root, cfg.StateFile)
+	if err != nil {
+		return err
+	}
+
+	byOutPath := make(map[string]*accords_mirrorrer.Download, len(state.Downloads))
+	versions := map[string]struct{}{}
+	for _, di := range state.Downloads {
+		byOutPath[di.OutPath] = di
+		for _, v := range di.Versions {
+			versions[v.OutPath] = struct{}{}
+		}
+	}
+
+	var candidates []*localFile
+	present := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		l := log.With(slog.String("path", f.Rel))
+
+		if _, ok := urlFromOutPath(f.Rel); !ok {
+			l.DebugContext(ctx, "skipping file outside of a host directory")
+			continue
+		}
+
+		if _, exists := versions[f.Rel]; exists {
+			l.DebugContext(ctx, "skipping previous version of a download")
+			continue
+		}
+
+		candidates = append(candidates, f)
+		present[f.Rel] = struct{}{}
+	}
+
+	// isVersion reports whether a file is a previous version of a download or of another file, returning its path.
+	isVersion := func(f *localFile) (string, bool) {
+		current, ok := mirror.CurrentPath(f.Rel)
+		if !ok {
+			return "", false
+		}
+
+		_, known := byOutPath[current]
+		_, exists := present[current]
+		return current, known || exists
+	}
+
+	// Only the files there's something to check against, and previous versions, are hashed.
+	// Without a hash or size, a file may be partial, so it's left pending.
+	var toHash []*localFile
+
+	var numAdded, numMatched, numMismatched, numUnverified, numVersions, numErrors int
+	for _, f := range candidates {
+		l := log.With(slog.String("path", f.Rel))
+
+		if _, ok := isVersion(f); ok {
+			toHash = append(toHash, f)
+			continue
+		}
+
+		di, exists := byOutPath[f.Rel]
+		if !exists {
+			u, _ := urlFromOutPath(f.Rel)
+			if di, err = state.AddDownload(u); err != nil {
+				l.With(slog.Any("error", err)).ErrorContext(ctx, "error adding download")
+				numErrors += 1
+				continue
+			}
+
+			byOutPath[f.Rel] = di
+			numAdded += 1
+		}
+
+		if _, known := di.KnownSize(); !known && di.SHA256 == "" {
+			l.DebugContext(ctx, "nothing to check the file against, leaving it pending")
+			numUnverified += 1
+			continue
+		}
+
+		toHash = append(toHash, f)
+	}
+
+	log.With(slog.Int("files", len(toHash))).InfoContext(ctx, "hashing files")
+
+	errs := hashFiles(ctx, toHash, opts.Parallelism)
+
+	var previous []*localFile
+	for i, f := range toHash {
+		l := log.With(slog.String("path", f.Rel))
+
+		if errs[i] != nil {
+			l.With(slog.Any("error", errs[i])).ErrorContext(ctx, "error hashing file")
+			numErrors += 1
+			continue
+		}
+
+		// Previous versions are attached once their download is known.
+		if _, ok := isVersion(f); ok {
+			previous = append(previous, f)
+			continue
+		}
+
+		if di := byOutPath[f.Rel]; adoptFile(di, f) {
+			numMatched += 1
+		} else {
+			l.With(slog.String("url", di.URL)).WarnContext(ctx, "file doesn't match the state, leaving it pending")
+			di.Completed = false
+			numMismatched += 1
+		}
+	}
+
+	// The time they were replaced is lost, so use when they were recovered.
+	recoveredAt := time.Now().UTC()
+	for _, f := range previous {
+		current, _ := isVersion(f)
+
+		di, exists := byOutPath[current]
+		if !exists {
+			log.With(slog.String("path", f.Rel)).WarnContext(ctx, "previous version of a file that couldn't be added, skipping it")
+			continue
+		}
+
+		di.Versions = append(di.Versions, accords_mirrorrer.FileVersion{
+			OutPath:    f.Rel,
+			Size:       f.Size,
+			SHA256:     f.SHA256,
+			ReplacedAt: recoveredAt,
+		})
+		numVersions += 1
+	}
+
+	log.With(
+		slog.Int("added", numAdded),
+		slog.Int("completed", numMatched),
+		slog.Int("mismatched", numMismatched),
+		slog.Int("unverified", numUnverified),
+		slog.Int("versions", numVersions),
+		slog.Int("errors", numErrors),
+	).InfoContext(ctx, "recovery finished")
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return accords_mirrorrer.SaveState(state, cfg.StateFile)
+}
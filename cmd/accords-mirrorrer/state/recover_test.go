@@ -0,0 +1,196 @@
+package state
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+	"git.vs49688.net/zane/accords-mirrorrer/cmd/accords-mirrorrer/config"
+)
+
+// testConfiguration returns a configuration for a state file in dir, logging nowhere.
+func testConfiguration(dir string) *configuration {
+	return &configuration{
+		Configuration: &config.Configuration{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))},
+		StateFile:     filepath.Join(dir, "state.json"),
+	}
+}
+
+// writeFiles writes the files, keyed by their slash-separated path under root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for rel, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testDownload(sha string, size, contentLength int64) accords_mirrorrer.Download {
+	var di accords_mirrorrer.Download
+	di.SHA256 = sha
+	di.Size = size
+	di.ContentLength = contentLength
+	return di
+}
+
+func TestAdoptFile(t *testing.T) {
+	const sha = "0123456789abcdef"
+
+	tests := []struct {
+		name string
+		di   accords_mirrorrer.Download
+		want bool
+	}{
+		{name: "nothing to check against", want: true},
+		{name: "same hash", di: testDownload(sha, 0, 0), want: true},
+		{name: "different hash", di: testDownload("fedcba9876543210", 10, 0), want: false},
+		{name: "hash wins over size", di: testDownload(sha, 5, 0), want: true},
+		{name: "same size", di: testDownload("", 10, 0), want: true},
+		{name: "different size", di: testDownload("", 5, 0), want: false},
+		{name: "same content length", di: testDownload("", 0, 10), want: true},
+		{name: "different content length", di: testDownload("", 0, 5), want: false},
+	}
+
+	for _, tt := range tests {
+		f := &localFile{Rel: "a.example.com/a.pdf", Size: 10, SHA256: sha}
+
+		if got := adoptFile(&tt.di, f); got != tt.want {
+			t.Errorf("%v: adopted = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+
+		if tt.want && (!tt.di.Completed || tt.di.Size != f.Size || tt.di.SHA256 != f.SHA256) {
+			t.Errorf("%v: adopted download wasn't completed with the file's size and hash", tt.name)
+		}
+	}
+}
+
+func TestRecoverState(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfiguration(root)
+
+	writeFiles(t, root, map[string]string{
+		"a.example.com/known.pdf":                  "known",
+		"a.example.com/partial.pdf":                "part",
+		"a.example.com/unknown.pdf":                "unknown",
+		"a.example.com/known.20260101T000000Z.pdf": "old",
+		"not-a-host/file.txt":                      "skipped",
+	})
+
+	state := accords_mirrorrer.NewState()
+	known, _ := state.AddDownload("https://a.example.com/known.pdf")
+	known.Size = int64(len("known"))
+	partial, _ := state.AddDownload("https://a.example.com/partial.pdf")
+	partial.ContentLength = 100
+
+	if err := accords_mirrorrer.SaveState(state, cfg.StateFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recoverState(context.Background(), cfg, recoverOptions{Root: root, Parallelism: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := accords_mirrorrer.LoadState(cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state.Downloads) != 3 {
+		t.Errorf("%v downloads, want 3", len(state.Downloads))
+	}
+
+	if d := state.Downloads["https://a.example.com/known.pdf"]; !d.Completed || d.SHA256 == "" {
+		t.Errorf("known download wasn't completed: %+v", d)
+	} else if len(d.Versions) != 1 || d.Versions[0].OutPath != "a.example.com/known.20260101T000000Z.pdf" || d.Versions[0].SHA256 == "" {
+		t.Errorf("previous version wasn't attached: %+v", d.Versions)
+	}
+
+	if d := state.Downloads["https://a.example.com/partial.pdf"]; d.Completed {
+		t.Errorf("partial download was completed: %+v", d)
+	}
+
+	if d := state.Downloads["https://a.example.com/unknown.pdf"]; d == nil || d.Completed || d.SHA256 != "" {
+		t.Errorf("unknown file wasn't left pending without hashing it: %+v", d)
+	}
+}
+
+func TestRecoverStateFresh(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfiguration(root)
+
+	writeFiles(t, root, map[string]string{
+		"a.example.com/a.pdf": "a",
+		"b.example.com/b.pdf": "b",
+	})
+
+	if err := recoverState(context.Background(), cfg, recoverOptions{Root: root, Parallelism: 1, Fresh: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := accords_mirrorrer.LoadState(cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state.Downloads) != 2 {
+		t.Errorf("%v downloads, want 2", len(state.Downloads))
+	}
+
+	for u, d := range state.Downloads {
+		if d.Completed || d.SHA256 != "" || d.Size != 0 {
+			t.Errorf("%v: expected a pending download, got %+v", u, d)
+		}
+	}
+}
+
+func TestImportDir(t *testing.T) {
+	root, foreign := t.TempDir(), t.TempDir()
+	cfg := testConfiguration(root)
+
+	writeFiles(t, foreign, map[string]string{
+		"a.example.com/new.pdf":   "never fetched",
+		"a.example.com/sized.pdf": "wrong size",
+	})
+
+	state := accords_mirrorrer.NewState()
+	_, _ = state.AddDownload("https://a.example.com/new.pdf")
+	sized, _ := state.AddDownload("https://a.example.com/sized.pdf")
+	sized.ContentLength = 1
+
+	if err := accords_mirrorrer.SaveState(state, cfg.StateFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := importDir(context.Background(), cfg, foreign, importOptions{Root: root, Mode: importModeCopy, Parallelism: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := accords_mirrorrer.LoadState(cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := state.Downloads["https://a.example.com/new.pdf"]; !d.Completed || d.SHA256 == "" {
+		t.Errorf("never fetched download wasn't imported: %+v", d)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "a.example.com", "new.pdf")); err != nil {
+		t.Errorf("imported file wasn't placed: %v", err)
+	}
+
+	if d := state.Downloads["https://a.example.com/sized.pdf"]; d.Completed {
+		t.Errorf("download of a different size was imported: %+v", d)
+	}
+}
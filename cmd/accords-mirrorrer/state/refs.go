@@ -0,0 +1,136 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+	"git.vs49688.net/zane/accords-mirrorrer/mirror"
+)
+
+type entityKey struct {
+	Kind string
+	Slug string
+}
+
+// loadStateWithRefs loads the state, rebuilding the download references in case
+// the entities have changed since they were last computed.
+func loadStateWithRefs(ctx context.Context, cfg *configuration) (*accords_mirrorrer.State, error) {
+	state, err := loadStateReadOnly(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mirror.UpdateRefs(state, cfg.Configuration.Logger); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// indexRefs maps each entity to the downloads referencing it.
+func indexRefs(state *accords_mirrorrer.State) map[entityKey][]*accords_mirrorrer.Download {
+	index := map[entityKey][]*accords_mirrorrer.Download{}
+
+	for _, u := range sortedKeys(state.Downloads) {
+		di := state.Downloads[u]
+
+		seen := map[entityKey]struct{}{}
+		for _, ref := range di.Refs {
+			k := entityKey{Kind: ref.Kind, Slug: ref.Slug}
+			if _, exists := seen[k]; exists {
+				continue
+			}
+			seen[k] = struct{}{}
+
+			index[k] = append(index[k], di)
+		}
+	}
+
+	return index
+}
+
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func whoUses(ctx context.Context, cfg *configuration, u string, format string) error {
+	state, err := loadStateWithRefs(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	di, exists := state.Downloads[u]
+	if !exists {
+		return fmt.Errorf("no such download: %v", u)
+	}
+
+	refs := append([]accords_mirrorrer.Ref(nil), di.Refs...)
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := refs[i], refs[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Slug != b.Slug {
+			return a.Slug < b.Slug
+		}
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		return a.Lang < b.Lang
+	})
+
+	switch format {
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "KIND\tSLUG\tROLE\tLANG")
+		for _, ref := range refs {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ref.Kind, ref.Slug, ref.Role, ref.Lang)
+		}
+		return w.Flush()
+	case "json":
+		return writeJSON(refs)
+	default:
+		return fmt.Errorf("unknown format: %v", format)
+	}
+}
+
+func entityAssets(ctx context.Context, cfg *configuration, kind, slug string, format string) error {
+	state, err := loadStateWithRefs(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if _, err := lookupEntity(state, kind, slug); err != nil {
+		return err
+	}
+
+	assets := indexRefs(state)[entityKey{Kind: kind, Slug: slug}]
+
+	switch format {
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "URL\tROLE\tLANG\tSIZE\tCOMPLETED")
+		for _, di := range assets {
+			for _, ref := range di.Refs {
+				if ref.Kind == kind && ref.Slug == slug {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%v\n", di.URL, ref.Role, ref.Lang, di.Size, di.Completed)
+				}
+			}
+		}
+		return w.Flush()
+	case "json":
+		if assets == nil {
+			assets = []*accords_mirrorrer.Download{}
+		}
+		return writeJSON(assets)
+	default:
+		return fmt.Errorf("unknown format: %v", format)
+	}
+}
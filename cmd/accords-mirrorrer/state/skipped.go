@@ -0,0 +1,116 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+type skippedOptions struct {
+	Format  string
+	Profile string
+}
+
+type skippedRecord struct {
+	URL       string   `json:"url"`
+	Reason    string   `json:"reason"`
+	Languages []string `json:"languages,omitempty"`
+	Size      *int64   `json:"size,omitempty"`
+}
+
+// refLanguages returns the language codes of the references of a download.
+func refLanguages(di *accords_mirrorrer.Download) []string {
+	set := map[string]struct{}{}
+	for _, ref := range di.Refs {
+		if ref.Lang != "" {
+			set[ref.Lang] = struct{}{}
+		}
+	}
+
+	langs := make([]string, 0, len(set))
+	for lang := range set {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	return langs
+}
+
+// listSkipped lists the pending downloads that a profile skips, and why.
+func listSkipped(ctx context.Context, cfg *configuration, opts skippedOptions) error {
+	state, err := loadStateReadOnly(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	p, exists := state.Profiles[opts.Profile]
+	if !exists && opts.Profile != accords_mirrorrer.DefaultProfile {
+		return fmt.Errorf("no such profile: %v", opts.Profile)
+	}
+
+	filter, err := p.Compile()
+	if err != nil {
+		return err
+	}
+
+	records := make([]skippedRecord, 0)
+	counts := map[string]int{}
+	for _, u := range sortedKeys(state.Downloads) {
+		di := state.Downloads[u]
+
+		reason := filter.Skips(di)
+		if di.Completed || reason == "" {
+			continue
+		}
+
+		rec := skippedRecord{
+			URL:       di.URL,
+			Reason:    reason,
+			Languages: refLanguages(di),
+		}
+
+		if size, known := di.KnownSize(); known {
+			rec.Size = &size
+		}
+
+		records = append(records, rec)
+		counts[reason] += 1
+	}
+
+	switch opts.Format {
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "URL\tREASON\tLANGUAGES\tSIZE")
+		for _, rec := range records {
+			langs, size := "-", "-"
+			if len(rec.Languages) > 0 {
+				langs = strings.Join(rec.Languages, ",")
+			}
+
+			if rec.Size != nil {
+				size = fmt.Sprint(*rec.Size)
+			}
+
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", rec.URL, rec.Reason, langs, size)
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		for _, reason := range sortedKeys(counts) {
+			_, _ = fmt.Fprintf(os.Stdout, "skipped by %s: %d\n", reason, counts[reason])
+		}
+
+		return nil
+	case "json":
+		return writeJSON(records)
+	default:
+		return fmt.Errorf("unknown format: %v", opts.Format)
+	}
+}
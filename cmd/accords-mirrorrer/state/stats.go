@@ -0,0 +1,251 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+type statsOptions struct {
+	Format string
+	Depth  int
+	Top    int
+}
+
+type downloadStats struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Pending   int `json:"pending"`
+
+	// Failed and Gone are the pending downloads that have failed, and that the server says don't exist.
+	Failed int `json:"failed"`
+	Gone   int `json:"gone"`
+
+	// CompletedBytes is the size of the completed downloads.
+	CompletedBytes int64 `json:"completed_bytes"`
+	// PendingKnownBytes is the size of the pending downloads with a known length.
+	PendingKnownBytes int64 `json:"pending_known_bytes"`
+	// PendingUnknown is the number of pending downloads without a known length.
+	PendingUnknown int `json:"pending_unknown"`
+	// RemainingBytes estimates the unknown downloads using the average size of the group.
+	RemainingBytes int64 `json:"estimated_remaining_bytes"`
+}
+
+type pendingFile struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
+type stateStats struct {
+	Entities map[string]int            `json:"entities"`
+	Total    downloadStats             `json:"downloads"`
+	ByHost   map[string]*downloadStats `json:"by_host"`
+	ByPath   map[string]*downloadStats `json:"by_path"`
+	Largest  []pendingFile             `json:"largest_pending"`
+}
+
+func (s *downloadStats) add(di *accords_mirrorrer.Download) {
+	s.Total += 1
+
+	size, known := di.KnownSize()
+
+	switch di.Status() {
+	case accords_mirrorrer.StatusFailed:
+		s.Failed += 1
+	case accords_mirrorrer.StatusGone:
+		s.Gone += 1
+	}
+
+	switch {
+	case di.Completed:
+		s.Completed += 1
+		s.CompletedBytes += size
+	case known:
+		s.Pending += 1
+		s.PendingKnownBytes += size
+	default:
+		s.Pending += 1
+		s.PendingUnknown += 1
+	}
+}
+
+func (s *downloadStats) averageSize() int64 {
+	if n := s.Total - s.PendingUnknown; n > 0 {
+		return (s.CompletedBytes + s.PendingKnownBytes) / int64(n)
+	}
+
+	return 0
+}
+
+// estimate fills in RemainingBytes, using fallback as the average size if nothing in the group has a known size.
+func (s *downloadStats) estimate(fallback int64) {
+	avg := s.averageSize()
+	if avg == 0 {
+		avg = fallback
+	}
+
+	s.RemainingBytes = s.PendingKnownBytes + avg*int64(s.PendingUnknown)
+}
+
+// downloadGroup returns the host, and the directory of the download truncated to depth components.
+func downloadGroup(di *accords_mirrorrer.Download, depth int) (string, string) {
+	u, err := url.Parse(di.URL)
+	if err != nil {
+		return "", ""
+	}
+
+	dir := strings.Trim(path.Dir(u.Path), "/")
+	if parts := strings.Split(dir, "/"); depth > 0 && len(parts) > depth {
+		dir = strings.Join(parts[:depth], "/")
+	}
+
+	return u.Host, path.Join(u.Host, dir)
+}
+
+func computeStats(state *accords_mirrorrer.State, opts statsOptions) *stateStats {
+	st := &stateStats{
+		Entities: map[string]int{},
+		ByHost:   map[string]*downloadStats{},
+		ByPath:   map[string]*downloadStats{},
+	}
+
+	for kind, entities := range state.RawEntities() {
+		st.Entities[kind] = len(entities)
+	}
+
+	var pending []pendingFile
+
+	for _, di := range state.Downloads {
+		host, dir := downloadGroup(di, opts.Depth)
+
+		if st.ByHost[host] == nil {
+			st.ByHost[host] = &downloadStats{}
+		}
+
+		if st.ByPath[dir] == nil {
+			st.ByPath[dir] = &downloadStats{}
+		}
+
+		st.Total.add(di)
+		st.ByHost[host].add(di)
+		st.ByPath[dir].add(di)
+
+		if size, known := di.KnownSize(); !di.Completed && known {
+			pending = append(pending, pendingFile{URL: di.URL, Size: size})
+		}
+	}
+
+	// Estimate per-path, as sizes vary wildly between scans and images.
+	fallback := st.Total.averageSize()
+
+	st.Total.RemainingBytes = 0
+	for _, ds := range st.ByPath {
+		ds.estimate(fallback)
+		st.Total.RemainingBytes += ds.RemainingBytes
+	}
+
+	for _, ds := range st.ByHost {
+		ds.RemainingBytes = 0
+	}
+
+	for dir, ds := range st.ByPath {
+		host, _, _ := strings.Cut(dir, "/")
+		if hs := st.ByHost[host]; hs != nil {
+			hs.RemainingBytes += ds.RemainingBytes
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Size != pending[j].Size {
+			return pending[i].Size > pending[j].Size
+		}
+
+		return pending[i].URL < pending[j].URL
+	})
+
+	if len(pending) > opts.Top {
+		pending = pending[:opts.Top]
+	}
+	st.Largest = pending
+
+	return st
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp += 1
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func writeStatsText(out io.Writer, st *stateStats) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(w, "KIND\tCOUNT")
+	for _, kind := range sortedKeys(st.Entities) {
+		_, _ = fmt.Fprintf(w, "%s\t%d\n", kind, st.Entities[kind])
+	}
+	_, _ = fmt.Fprintln(w)
+
+	writeGroup := func(title string, groups map[string]*downloadStats) {
+		_, _ = fmt.Fprintf(w, "%s\tTOTAL\tCOMPLETED\tPENDING\tFAILED\tGONE\tUNKNOWN SIZE\tCOMPLETED BYTES\tREMAINING BYTES (EST.)\n", title)
+		for _, name := range sortedKeys(groups) {
+			ds := groups[name]
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+				name, ds.Total, ds.Completed, ds.Pending, ds.Failed, ds.Gone, ds.PendingUnknown,
+				formatBytes(ds.CompletedBytes), formatBytes(ds.RemainingBytes),
+			)
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+
+	writeGroup("HOST", st.ByHost)
+	writeGroup("PATH", st.ByPath)
+	writeGroup("DOWNLOADS", map[string]*downloadStats{"total": &st.Total})
+
+	if len(st.Largest) > 0 {
+		_, _ = fmt.Fprintln(w, "LARGEST PENDING\tSIZE")
+		for _, pf := range st.Largest {
+			_, _ = fmt.Fprintf(w, "%s\t%s\n", pf.URL, formatBytes(pf.Size))
+		}
+	}
+
+	return w.Flush()
+}
+
+func showStats(ctx context.Context, cfg *configuration, opts statsOptions) error {
+	state, err := loadStateReadOnly(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	st := computeStats(state, opts)
+
+	switch opts.Format {
+	case "text":
+		return writeStatsText(os.Stdout, st)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	default:
+		return fmt.Errorf("unknown format: %v", opts.Format)
+	}
+}
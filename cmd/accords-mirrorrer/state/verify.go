@@ -0,0 +1,160 @@
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+
+	"git.vs49688.net/zane/goutils"
+	"git.vs49688.net/zane/goutils/parallel"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+type verifyOptions struct {
+	Root        string
+	Parallelism int
+	Sample      int
+	Reset       bool
+}
+
+var (
+	errMissing      = errors.New("missing")
+	errSizeMismatch = errors.New("size mismatch")
+	errHashMismatch = errors.New("hash mismatch")
+)
+
+// hashFile returns the size and hex SHA256 of a file.
+func hashFile(p string) (int64, string, error) {
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	hr := goutils.NewHashReader(f, sha256.New)
+	if _, err := io.Copy(io.Discard, hr); err != nil {
+		return 0, "", err
+	}
+
+	return hr.GetSize(), hex.EncodeToString(hr.Hash()), nil
+}
+
+func verifyFile(root string, di *accords_mirrorrer.Download) error {
+	p := filepath.Join(root, filepath.FromSlash(di.OutPath))
+
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return errMissing
+	} else if err != nil {
+		return err
+	}
+
+	if di.Size > 0 && fi.Size() != di.Size {
+		return fmt.Errorf("%w: expected %d, got %d", errSizeMismatch, di.Size, fi.Size())
+	}
+
+	if di.SHA256 == "" {
+		return nil
+	}
+
+	_, sum, err := hashFile(p)
+	if err != nil {
+		return err
+	}
+
+	if sum != di.SHA256 {
+		return fmt.Errorf("%w: expected %v, got %v", errHashMismatch, di.SHA256, sum)
+	}
+
+	return nil
+}
+
+func verifyState(ctx context.Context, cfg *configuration, opts verifyOptions) error {
+	log := cfg.Configuration.Logger
+
+	state, release, err := openState(ctx, cfg, opts.Reset, "state verify")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var dis []*accords_mirrorrer.Download
+	for _, u := range sortedKeys(state.Downloads) {
+		if di := state.Downloads[u]; di.Completed {
+			dis = append(dis, di)
+		}
+	}
+
+	if opts.Sample > 0 && opts.Sample < len(dis) {
+		rand.Shuffle(len(dis), func(i, j int) { dis[i], dis[j] = dis[j], dis[i] })
+		dis = dis[:opts.Sample]
+	}
+
+	log.With(slog.Int("files", len(dis))).InfoContext(ctx, "verifying files")
+
+	errs := parallel.Parallel(ctx, opts.Parallelism, dis, func(ctx context.Context, di *accords_mirrorrer.Download) error {
+		return verifyFile(opts.Root, di)
+	})
+
+	var numMissing, numSize, numHash, numOther int
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		di := dis[i]
+
+		switch {
+		case errors.Is(err, errMissing):
+			numMissing += 1
+		case errors.Is(err, errSizeMismatch):
+			numSize += 1
+		case errors.Is(err, errHashMismatch):
+			numHash += 1
+		default:
+			numOther += 1
+		}
+
+		fmt.Printf("%s\t%s\t%v\n", di.URL, di.OutPath, err)
+
+		if opts.Reset {
+			di.Completed = false
+		}
+	}
+
+	numBad := numMissing + numSize + numHash + numOther
+
+	log.With(
+		slog.Int("checked", len(dis)),
+		slog.Int("missing", numMissing),
+		slog.Int("size_mismatch", numSize),
+		slog.Int("hash_mismatch", numHash),
+		slog.Int("errors", numOther),
+	).InfoContext(ctx, "verification finished")
+
+	if opts.Reset && numBad > 0 {
+		if err := accords_mirrorrer.SaveState(state, cfg.StateFile); err != nil {
+			return err
+		}
+
+		log.With(slog.Int("count", numBad)).InfoContext(ctx, "reset completion flag of bad files")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if numBad > 0 {
+		return fmt.Errorf("%d of %d files failed verification", numBad, len(dis))
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+func TestVerifyFile(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a.example.com"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("content")
+	if err := os.WriteFile(filepath.Join(root, "a.example.com", "a.pdf"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+
+	tests := []struct {
+		name    string
+		outPath string
+		size    int64
+		sha     string
+		want    error
+	}{
+		{name: "matches", outPath: "a.example.com/a.pdf", size: 7, sha: hex.EncodeToString(sum[:])},
+		{name: "nothing to check", outPath: "a.example.com/a.pdf"},
+		{name: "missing", outPath: "a.example.com/b.pdf", want: errMissing},
+		{name: "size", outPath: "a.example.com/a.pdf", size: 8, sha: hex.EncodeToString(sum[:]), want: errSizeMismatch},
+		{name: "hash", outPath: "a.example.com/a.pdf", size: 7, sha: "00", want: errHashMismatch},
+	}
+
+	for _, tt := range tests {
+		var di accords_mirrorrer.Download
+		di.OutPath = tt.outPath
+		di.Size = tt.size
+		di.SHA256 = tt.sha
+
+		if err := verifyFile(root, &di); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%v: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
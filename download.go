@@ -0,0 +1,195 @@
+package accords_mirrorrer
+
+import (
+	"net/http"
+	"time"
+
+	"git.vs49688.net/zane/goutils/download"
+)
+
+// Roles of a download within the entity referencing it.
+const (
+	RoleThumbnail      = "thumbnail"
+	RoleImage          = "image"
+	RoleScan           = "scan"
+	RoleScanPage       = "scan_page"
+	RoleTrack          = "track"
+	RoleAudio          = "audio"
+	RoleVideo          = "video"
+	RoleSubtitle       = "subtitle"
+	RoleOpenGraphImage = "og_image"
+	RoleOpenGraphAudio = "og_audio"
+	RoleOpenGraphVideo = "og_video"
+	RoleScrape         = "scrape"
+)
+
+// KindScrape is the kind of the references added by the asset storage scrape.
+const KindScrape = "scrape"
+
+// Ref records why a download was added.
+type Ref struct {
+	Kind string `json:"kind"`
+	Slug string `json:"slug,omitempty"`
+	Role string `json:"role"`
+	Lang string `json:"lang,omitempty"`
+}
+
+// WithRole returns a copy of the reference with the given role.
+func (r Ref) WithRole(role string) Ref {
+	r.Role = role
+	return r
+}
+
+// WithLang returns a copy of the reference with the given language code.
+func (r Ref) WithLang(lang string) Ref {
+	r.Lang = lang
+	return r
+}
+
+// Statuses of a download.
+const (
+	StatusCompleted = "completed"
+	StatusPending   = "pending"
+	StatusFailed    = "failed"
+
+	// StatusGone is a download the server says doesn't exist.
+	StatusGone = "gone"
+)
+
+const (
+	// RetryBackoff is how long to wait before retrying a failed download, doubling with each attempt.
+	RetryBackoff = 5 * time.Minute
+
+	// MaxRetryBackoff caps RetryBackoff.
+	MaxRetryBackoff = 24 * time.Hour
+)
+
+// Download is a download.DownloadInfo, along with what references it.
+type Download struct {
+	download.DownloadInfo
+
+	// Attempts is the number of failed attempts since the download last succeeded.
+	Attempts    int        `json:"attempts,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastStatus  int        `json:"last_status,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+
+	// ETag and LastModified are the validators of the last response, for rechecking completed downloads.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// Versions are the previous versions of the file, kept when it changed upstream.
+	Versions []FileVersion `json:"versions,omitempty"`
+
+	Refs []Ref `json:"refs,omitempty"`
+}
+
+// FileVersion is a previous version of a download, moved aside when it changed upstream.
+type FileVersion struct {
+	OutPath      string    `json:"out_path"`
+	Size         int64     `json:"size,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ReplacedAt   time.Time `json:"replaced_at"`
+}
+
+// KnownSize returns the size of the download, if known.
+func (d *Download) KnownSize() (int64, bool) {
+	if d.Size > 0 {
+		return d.Size, true
+	}
+
+	if d.ContentLength > 0 {
+		return d.ContentLength, true
+	}
+
+	return 0, false
+}
+
+// Status returns the status of the download, one of the Status constants.
+func (d *Download) Status() string {
+	switch {
+	case d.Completed:
+		return StatusCompleted
+	case d.LastStatus == http.StatusNotFound || d.LastStatus == http.StatusGone:
+		return StatusGone
+	case d.Attempts > 0:
+		return StatusFailed
+	default:
+		return StatusPending
+	}
+}
+
+// NextAttempt returns when a failed download should next be retried.
+func (d *Download) NextAttempt() time.Time {
+	if d.Attempts == 0 || d.LastAttempt == nil {
+		return time.Time{}
+	}
+
+	backoff := RetryBackoff
+	for i := 1; i < d.Attempts && backoff < MaxRetryBackoff; i += 1 {
+		backoff *= 2
+	}
+
+	return d.LastAttempt.Add(min(backoff, MaxRetryBackoff))
+}
+
+// RecordFailure records a failed attempt. status is the HTTP status, or 0 if there wasn't a response.
+func (d *Download) RecordFailure(err error, status int, at time.Time) {
+	d.Attempts += 1
+	d.LastError = err.Error()
+	d.LastStatus = status
+	d.LastAttempt = &at
+}
+
+// ClearFailures forgets the failed attempts, after a success.
+func (d *Download) ClearFailures() {
+	d.Attempts = 0
+	d.LastError = ""
+	d.LastStatus = 0
+	d.LastAttempt = nil
+}
+
+// KeepVersion records the current file as a previous version, moved to outPath, and resets the
+// download so it's fetched again.
+func (d *Download) KeepVersion(outPath string, at time.Time) {
+	d.Versions = append(d.Versions, FileVersion{
+		OutPath:      outPath,
+		Size:         d.Size,
+		SHA256:       d.SHA256,
+		ETag:         d.ETag,
+		LastModified: d.LastModified,
+		ReplacedAt:   at,
+	})
+
+	d.Size = 0
+	d.ContentLength = 0
+	d.SHA256 = ""
+	d.Completed = false
+	d.ETag = ""
+	d.LastModified = ""
+	d.ClearFailures()
+}
+
+// AddRef adds a reference, ignoring duplicates.
+func (d *Download) AddRef(ref Ref) {
+	for _, r := range d.Refs {
+		if r == ref {
+			return
+		}
+	}
+
+	d.Refs = append(d.Refs, ref)
+}
+
+// HasRole reports whether anything references the download with the given role.
+func (d *Download) HasRole(role string) bool {
+	for _, r := range d.Refs {
+		if r.Role == role {
+			return true
+		}
+	}
+
+	return false
+}
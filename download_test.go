@@ -0,0 +1,73 @@
+package accords_mirrorrer
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDownloadStatus(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		update func(d *Download)
+		want   string
+	}{
+		{name: "new", update: func(d *Download) {}, want: StatusPending},
+		{name: "completed", update: func(d *Download) { d.Completed = true }, want: StatusCompleted},
+		{name: "failed", update: func(d *Download) { d.RecordFailure(errors.New("x"), http.StatusInternalServerError, at) }, want: StatusFailed},
+		{name: "no response", update: func(d *Download) { d.RecordFailure(errors.New("x"), 0, at) }, want: StatusFailed},
+		{name: "not found", update: func(d *Download) { d.RecordFailure(errors.New("x"), http.StatusNotFound, at) }, want: StatusGone},
+		{name: "gone", update: func(d *Download) { d.RecordFailure(errors.New("x"), http.StatusGone, at) }, want: StatusGone},
+		{
+			name: "cleared",
+			update: func(d *Download) {
+				d.RecordFailure(errors.New("x"), http.StatusNotFound, at)
+				d.ClearFailures()
+			},
+			want: StatusPending,
+		},
+	}
+
+	for _, tt := range tests {
+		d := &Download{}
+		tt.update(d)
+
+		if got := d.Status(); got != tt.want {
+			t.Errorf("%v: status = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	d := &Download{}
+	if next := d.NextAttempt(); !next.IsZero() {
+		t.Errorf("a download that hasn't failed can be retried at %v", next)
+	}
+
+	want := []time.Duration{
+		5 * time.Minute,
+		10 * time.Minute,
+		20 * time.Minute,
+		40 * time.Minute,
+	}
+
+	for i, backoff := range want {
+		d.RecordFailure(errors.New("x"), 0, at)
+		if next := d.NextAttempt(); !next.Equal(at.Add(backoff)) {
+			t.Errorf("after %v attempts: next attempt after %v, want %v", i+1, next.Sub(at), backoff)
+		}
+	}
+
+	for i := 0; i < 20; i += 1 {
+		d.RecordFailure(errors.New("x"), 0, at)
+	}
+
+	if next := d.NextAttempt(); !next.Equal(at.Add(MaxRetryBackoff)) {
+		t.Errorf("backoff isn't capped: next attempt after %v", next.Sub(at))
+	}
+}
@@ -0,0 +1,66 @@
+package accords_mirrorrer
+
+// Entities is a typed view of a raw entity map. Entities are decoded on first access,
+// and decoded again if the raw entity has since been replaced.
+// Like a map, it isn't safe for concurrent use.
+type Entities[T any] struct {
+	raw   map[string]*RawEntity
+	cache map[string]decoded[T]
+}
+
+type decoded[T any] struct {
+	raw *RawEntity
+	val *T
+}
+
+func newEntities[T any](raw map[string]*RawEntity) *Entities[T] {
+	return &Entities[T]{
+		raw:   raw,
+		cache: map[string]decoded[T]{},
+	}
+}
+
+// Get returns the entity with the given slug, or nil if there isn't one.
+func (e *Entities[T]) Get(slug string) (*T, error) {
+	raw := e.raw[slug]
+	if raw == nil {
+		delete(e.cache, slug)
+		return nil, nil
+	}
+
+	if d, exists := e.cache[slug]; exists && d.raw == raw {
+		return d.val, nil
+	}
+
+	val, err := unraw[T](raw)
+	if err != nil {
+		return nil, err
+	}
+
+	e.cache[slug] = decoded[T]{raw: raw, val: val}
+	return val, nil
+}
+
+// Set stores the raw entity, and its already-decoded value.
+func (e *Entities[T]) Set(slug string, raw *RawEntity, val *T) {
+	e.raw[slug] = raw
+	e.cache[slug] = decoded[T]{raw: raw, val: val}
+}
+
+// All decodes every entity.
+func (e *Entities[T]) All() (map[string]*T, error) {
+	out := make(map[string]*T, len(e.raw))
+
+	for slug := range e.raw {
+		val, err := e.Get(slug)
+		if err != nil {
+			return nil, err
+		}
+
+		if val != nil {
+			out[slug] = val
+		}
+	}
+
+	return out, nil
+}
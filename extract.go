@@ -0,0 +1,222 @@
+package accords_mirrorrer
+
+import (
+	"log/slog"
+	"net/url"
+
+	"git.vs49688.net/zane/accords-mirrorrer/library"
+)
+
+func addOpenGraph(og *library.OpenGraph, ref Ref, state *State, l *slog.Logger) {
+	if url := og.Thumbnail.Image; url != "" {
+		if _, err := state.AddDownload(url, ref.WithRole(RoleOpenGraphImage)); err != nil {
+			l.With(slog.String("url", url)).Warn("error adding thumbnail download")
+		}
+	}
+
+	if og.Audio != "" {
+		if _, err := state.AddDownload(og.Audio, ref.WithRole(RoleOpenGraphAudio)); err != nil {
+			l.With(slog.String("url", og.Audio)).Warn("error adding audio download")
+		}
+	}
+
+	if og.Video != "" {
+		if _, err := state.AddDownload(og.Video, ref.WithRole(RoleOpenGraphVideo)); err != nil {
+			l.With(slog.String("url", og.Video)).Warn("error adding video download")
+		}
+	}
+}
+
+func addURL(u string, baseURL *url.URL, ref Ref, state *State, l *slog.Logger) {
+	uu, err := url.Parse(u)
+	if err != nil {
+		l.With(slog.Any("error", err)).Warn("error parsing url")
+		return
+	}
+
+	finalUrl := baseURL.ResolveReference(uu)
+
+	if _, err := state.AddDownload(finalUrl.String(), ref); err != nil {
+		l.With(slog.Any("error", err)).Warn("error adding url")
+	}
+}
+
+func addUploadFileImage(uf *library.UploadFile, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	if uf == nil || uf.Url == "" {
+		return
+	}
+
+	addURL(uf.Url, client.GetCMSUrl(), ref, state, l)
+}
+
+func addUploadImageFragment(uif *library.UploadImageFragment, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	if uif == nil || uif.URL == "" {
+		return
+	}
+
+	addURL(uif.URL, client.GetCMSUrl(), ref, state, l)
+}
+
+func addUploadFileEntityResponse(uifr *library.UploadFileEntityResponse, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	if uifr == nil || uifr.Data == nil {
+		return
+	}
+
+	addUploadFileImage(uifr.Data.Attributes, ref, client, state, l)
+}
+
+func extractContents(item *library.Content, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	if item == nil {
+		return
+	}
+
+	slug := item.Slug
+	l = l.With(slog.String("slug", slug))
+
+	addUploadFileEntityResponse(item.Thumbnail, ref.WithRole(RoleThumbnail), client, state, l)
+
+	for _, tl := range item.Translations {
+		if tl.Language == nil || tl.Language.Data == nil || tl.Language.Data.Attributes == nil || tl.Language.Data.Attributes.Code == "" {
+			continue
+		}
+		langCode := tl.Language.Data.Attributes.Code
+
+		ll := l.With(slog.Any("lang_code", langCode))
+		lref := ref.WithLang(langCode)
+
+		// src/pages/contents/[slug].tsx
+		if tl.Audio_set != nil {
+			audioURL := client.BuildAudioURL(slug, langCode)
+
+			if _, err := state.AddDownload(audioURL.String(), lref.WithRole(RoleAudio)); err != nil {
+				ll.With(slog.Any("error", err)).Warn("error adding audio url")
+			}
+		}
+
+		if tl.Video_set != nil {
+			// NB: the frontend always attempts this, even if it doesn't exist
+			subURL := client.BuildVTTURL(slug, langCode)
+			if _, err := state.AddDownload(subURL.String(), lref.WithRole(RoleSubtitle)); err != nil {
+				ll.With(slog.Any("error", err)).Warn("error adding subtitle url")
+			}
+
+			videoURL := client.BuildVideoURL(slug, langCode)
+			if _, err := state.AddDownload(videoURL.String(), lref.WithRole(RoleVideo)); err != nil {
+				ll.With(slog.Any("error", err)).Warn("error adding video url")
+			}
+		}
+	}
+}
+
+func gatherTracks(item *library.LibraryItem, ref Ref, state *State, lc library.Client, l *slog.Logger) {
+	for _, md := range item.Metadata {
+		audioMeta, ok := md.Value.(*library.ComponentMetadataAudio)
+		if !ok {
+			continue
+		}
+
+		for _, t := range audioMeta.Tracks {
+			trackURL := lc.BuildTrackURL(item.Slug, t.Slug)
+			if _, err := state.AddDownload(trackURL.String(), ref.WithRole(RoleTrack)); err != nil {
+				l.With(slog.Any("error", err)).Warn("unable to add track")
+			}
+		}
+	}
+}
+
+// withLanguage returns a copy of the reference with the code of the language, if there is one.
+func withLanguage(ref Ref, lang *library.LanguageEntityResponse) Ref {
+	if lang == nil || lang.Data == nil || lang.Data.Attributes == nil || lang.Data.Attributes.Code == "" {
+		return ref
+	}
+
+	return ref.WithLang(lang.Data.Attributes.Code)
+}
+
+func extractLibraryItem(item *library.LibraryItem, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	l = l.With(slog.String("slug", item.Slug))
+
+	// Grab the thumbnail image.
+	addUploadFileEntityResponse(item.Thumbnail, ref.WithRole(RoleThumbnail), client, state, l)
+
+	// Grab the scan archive.
+	if item.Download_available {
+		archiveURL := client.BuildScanArchiveURL(item.Slug)
+
+		if _, err := state.AddDownload(archiveURL.String(), ref.WithRole(RoleScan)); err != nil {
+			l.With(slog.Any("error", err)).Warn("error scan url")
+		}
+	}
+
+	// Grab the tracks.
+	gatherTracks(item, ref, state, client, l)
+
+	// Now drill down into the contents. Only Reader should have these.
+	if item.Contents != nil {
+		for _, ct := range item.Contents.Data {
+			if ct.Attributes == nil {
+				continue
+			}
+
+			for _, ss := range ct.Attributes.Scan_set {
+				if ss.Pages == nil {
+					continue
+				}
+
+				pageRef := withLanguage(ref.WithRole(RoleScanPage), ss.Language)
+				for _, page := range ss.Pages.Data {
+					addUploadFileImage(page.Attributes, pageRef, client, state, l)
+				}
+			}
+		}
+	}
+}
+
+func extractWeaponStory(item *library.WeaponStory, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	if item == nil {
+		return
+	}
+
+	addUploadFileEntityResponse(item.Thumbnail, ref.WithRole(RoleThumbnail), client, state, l)
+
+	if item.Weapon_group != nil && item.Weapon_group.Data != nil && item.Weapon_group.Data.Attributes != nil && item.Weapon_group.Data.Attributes.Weapons != nil {
+		for _, wg := range item.Weapon_group.Data.Attributes.Weapons.Data {
+			if wg.Attributes != nil {
+				addUploadFileEntityResponse(wg.Attributes.Thumbnail, ref.WithRole(RoleImage), client, state, l)
+			}
+		}
+	}
+}
+
+func extractVideo(item *library.VideoProps, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	if item == nil || item.Video.Uid == "" {
+		return
+	}
+
+	l = l.With(slog.String("uid", item.Video.Uid))
+
+	thumbURL := client.BuildVideoThumbnailURL(item.Video.Uid)
+
+	if _, err := state.AddDownload(thumbURL.String(), ref.WithRole(RoleThumbnail)); err != nil {
+		l.With(slog.Any("error", err)).Warn("error adding video thumbnail")
+	}
+
+	videoURL := client.BuildVideoFileURL(item.Video.Uid)
+	if _, err := state.AddDownload(videoURL.String(), ref.WithRole(RoleVideo)); err != nil {
+		l.With(slog.Any("error", err)).Warn("error adding video")
+	}
+}
+
+func extractChronicle(item *library.Chronicle, ref Ref, client library.Client, state *State, l *slog.Logger) {
+	if item == nil {
+		return
+	}
+
+	if item.Contents != nil {
+		for _, ct := range item.Contents.Data {
+			if ct.Attributes != nil {
+				addUploadFileEntityResponse(ct.Attributes.Thumbnail, ref.WithRole(RoleThumbnail), client, state, l)
+			}
+		}
+	}
+}
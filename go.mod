@@ -7,13 +7,14 @@ toolchain go1.23.2
 require (
 	git.sr.ht/~emersion/gqlclient v0.0.0-20230820050442-8873fe0204b9
 	git.vs49688.net/zane/goutils v0.0.0-20241024115648-ce894dbd7e78
+	github.com/cavaliergopher/grab/v3 v3.0.1
+	github.com/klauspost/compress v1.18.0
 	github.com/urfave/cli/v2 v2.27.5
 )
 
 require (
 	github.com/PuerkitoBio/goquery v1.10.0 // indirect
 	github.com/andybalholm/cascadia v1.3.2 // indirect
-	github.com/cavaliergopher/grab/v3 v3.0.1 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
@@ -0,0 +1,246 @@
+package accords_mirrorrer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"git.vs49688.net/zane/accords-mirrorrer/library"
+)
+
+// Kind is a registered EntityKind, without its type.
+type Kind interface {
+	// GetName returns the name of the kind, as used on the command line and in download references.
+	GetName() string
+
+	// GetKey returns where the entities are stored in the state file. Nested objects are separated by dots.
+	GetKey() string
+
+	// IsSingleton returns whether the kind has a single entity, with an empty slug.
+	IsSingleton() bool
+
+	// Refresh searches for entities of this kind, fetching any that are missing.
+	// Singletons are always fetched.
+	Refresh(ctx context.Context, state *State, client library.Client, log *slog.Logger) error
+
+	// ExtractDownloads adds the downloads referenced by an entity to dst, including its OpenGraph media.
+	ExtractDownloads(state *State, slug string, client library.Client, dst *State, log *slog.Logger) error
+
+	// Related returns the entities an entity refers to, such as its subitems and contents.
+	// The entity is fetched if it's missing.
+	Related(ctx context.Context, state *State, client library.Client, slug string) ([]Ref, error)
+}
+
+// EntityKind describes a kind of entity: where it's stored, and how to find, fetch and extract it.
+// T must be a library props type, with Raw and Fetch fields.
+type EntityKind[T any] struct {
+	Name      string
+	Key       string
+	Singleton bool
+
+	// Fetch fetches a single entity.
+	Fetch func(ctx context.Context, client library.Client, slug string) (*T, error)
+
+	// Search returns the slugs of the entities to fetch. It may use entities of kinds registered before it.
+	// It may be nil for singletons.
+	Search func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error)
+
+	// Extract adds the downloads referenced by an entity to dst, other than its OpenGraph media.
+	Extract func(item *T, ref Ref, client library.Client, dst *State, log *slog.Logger)
+
+	// Relations returns the entities an entity refers to, as references without roles. It may be nil.
+	Relations func(item *T) []Ref
+}
+
+var kinds []Kind
+
+// RegisterKind adds a kind to the registry. Kinds are refreshed and saved in the order they're registered.
+// It must be called before any state is loaded, usually from a package-level variable declaration.
+func RegisterKind[T any](k *EntityKind[T]) *EntityKind[T] {
+	if k.Key == "" {
+		k.Key = k.Name
+	}
+
+	switch root, _, _ := strings.Cut(k.Key, "."); root {
+	case "version", "downloads", "scraped", "profiles":
+		panic(fmt.Sprintf("key of kind %v is reserved", k.Name))
+	}
+
+	for _, existing := range kinds {
+		if existing.GetName() == k.Name || existing.GetKey() == k.Key {
+			panic(fmt.Sprintf("kind %v conflicts with %v", k.Name, existing.GetName()))
+		}
+
+		// A key can't be both an entity map and a group.
+		if strings.HasPrefix(existing.GetKey(), k.Key+".") || strings.HasPrefix(k.Key, existing.GetKey()+".") {
+			panic(fmt.Sprintf("key of kind %v conflicts with %v", k.Name, existing.GetName()))
+		}
+	}
+
+	kinds = append(kinds, k)
+	return k
+}
+
+// Kinds returns the registered kinds, in registration order.
+func Kinds() []Kind {
+	return append([]Kind(nil), kinds...)
+}
+
+// LookupKind returns the registered kind with the given name.
+func LookupKind(name string) (Kind, bool) {
+	for _, k := range kinds {
+		if k.GetName() == name {
+			return k, true
+		}
+	}
+
+	return nil, false
+}
+
+func (k *EntityKind[T]) GetName() string {
+	return k.Name
+}
+
+func (k *EntityKind[T]) GetKey() string {
+	return k.Key
+}
+
+func (k *EntityKind[T]) IsSingleton() bool {
+	return k.Singleton
+}
+
+func (k *EntityKind[T]) entities(state *State) *Entities[T] {
+	if e, exists := state.views[k.Name].(*Entities[T]); exists {
+		return e
+	}
+
+	e := newEntities[T](state.rawEntities(k.Name))
+	state.views[k.Name] = e
+	return e
+}
+
+// Get returns the entity with the given slug, or nil if there isn't one.
+func (k *EntityKind[T]) Get(state *State, slug string) (*T, error) {
+	return k.entities(state).Get(slug)
+}
+
+// All decodes every entity of this kind.
+func (k *EntityKind[T]) All(state *State) (map[string]*T, error) {
+	return k.entities(state).All()
+}
+
+// Put stores a freshly-fetched entity, keeping the previous version in its history.
+func (k *EntityKind[T]) Put(state *State, slug string, item *T) {
+	v := reflect.ValueOf(item).Elem()
+	raw := v.FieldByName("Raw").Bytes()
+	info, _ := v.FieldByName("Fetch").Interface().(*library.FetchInfo)
+
+	e := k.entities(state)
+	e.Set(slug, UpdateRawEntity(e.raw[slug], NewRawEntity(raw, info)), item)
+}
+
+func (k *EntityKind[T]) fetch(ctx context.Context, state *State, client library.Client, slug string) (*T, error) {
+	item, err := k.Fetch(ctx, client, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Put(state, slug, item)
+
+	if state.onFetched != nil {
+		state.onFetched(ctx, k, slug, state.rawEntities(k.Name)[slug])
+	}
+
+	return item, nil
+}
+
+// needsFetch returns whether the entity with the given slug is missing or stale.
+func (k *EntityKind[T]) needsFetch(state *State, slug string) bool {
+	e := state.rawEntities(k.Name)[slug]
+	return e == nil || state.isStale(e)
+}
+
+// Ensure returns the entity with the given slug, fetching it if it's missing or stale.
+func (k *EntityKind[T]) Ensure(ctx context.Context, state *State, client library.Client, slug string) (*T, error) {
+	if !k.needsFetch(state, slug) {
+		return k.Get(state, slug)
+	}
+
+	item, err := k.fetch(ctx, state, client, slug)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %v %v: %w", k.Name, slug, err)
+	}
+
+	return item, nil
+}
+
+func (k *EntityKind[T]) Refresh(ctx context.Context, state *State, client library.Client, log *slog.Logger) error {
+	log = log.With(slog.String("kind", k.Name))
+
+	if k.Singleton {
+		log.InfoContext(ctx, "fetching entity")
+
+		if _, err := k.fetch(ctx, state, client, ""); err != nil {
+			log.With(slog.Any("error", err)).ErrorContext(ctx, "error fetching entity")
+			return fmt.Errorf("error fetching %v: %w", k.Name, err)
+		}
+
+		return nil
+	}
+
+	if k.Search == nil {
+		return nil
+	}
+
+	slugs, err := k.Search(ctx, state, client, log)
+	if err != nil {
+		log.With(slog.Any("error", err)).ErrorContext(ctx, "error searching")
+		return err
+	}
+
+	for _, slug := range slugs {
+		if !k.needsFetch(state, slug) {
+			continue
+		}
+
+		l := log.With(slog.String("slug", slug))
+		l.InfoContext(ctx, "fetching entity")
+
+		if _, err := k.Ensure(ctx, state, client, slug); err != nil {
+			l.With(slog.Any("error", err)).ErrorContext(ctx, "error fetching entity")
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *EntityKind[T]) ExtractDownloads(state *State, slug string, client library.Client, dst *State, log *slog.Logger) error {
+	item, err := k.Get(state, slug)
+	if err != nil || item == nil {
+		return err
+	}
+
+	ref := Ref{Kind: k.Name, Slug: slug}
+
+	if e, ok := any(item).(library.Entity); ok {
+		addOpenGraph(e.GetOpenGraph(), ref, dst, log)
+	}
+
+	if k.Extract != nil {
+		k.Extract(item, ref, client, dst, log)
+	}
+
+	return nil
+}
+
+func (k *EntityKind[T]) Related(ctx context.Context, state *State, client library.Client, slug string) ([]Ref, error) {
+	item, err := k.Ensure(ctx, state, client, slug)
+	if err != nil || k.Relations == nil {
+		return nil, err
+	}
+
+	return k.Relations(item), nil
+}
@@ -0,0 +1,66 @@
+package accords_mirrorrer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.vs49688.net/zane/accords-mirrorrer/library"
+)
+
+type testEntity struct {
+	Slug string `json:"slug"`
+
+	Raw   json.RawMessage    `json:"-"`
+	Fetch *library.FetchInfo `json:"-"`
+}
+
+func TestEntityKindRefresh(t *testing.T) {
+	var fetched []string
+
+	k := &EntityKind[testEntity]{
+		Name: "test",
+		Fetch: func(ctx context.Context, client library.Client, slug string) (*testEntity, error) {
+			fetched = append(fetched, slug)
+
+			raw, _ := json.Marshal(testEntity{Slug: slug})
+			return &testEntity{Slug: slug, Raw: raw, Fetch: &library.FetchInfo{FetchedAt: time.Now().UTC()}}, nil
+		},
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			return []string{"cached", "stale", "missing"}, nil
+		},
+	}
+
+	state := NewState()
+	state.RefetchOlderThan(time.Hour)
+
+	entities := state.rawEntities(k.Name)
+	entities["cached"] = NewRawEntity(json.RawMessage(`{"slug":"cached"}`), &library.FetchInfo{FetchedAt: time.Now().UTC()})
+	entities["stale"] = NewRawEntity(json.RawMessage(`{"slug":"stale"}`), &library.FetchInfo{FetchedAt: time.Now().Add(-2 * time.Hour).UTC()})
+
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil))
+
+	if err := k.Refresh(context.Background(), state, library.NewClient(nil), log); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"stale", "missing"}; !slices.Equal(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+
+	if n := strings.Count(buf.String(), `msg="fetching entity"`); n != 2 {
+		t.Errorf("logged fetching %v entities, want 2:\n%v", n, buf.String())
+	}
+
+	for _, slug := range []string{"cached", "stale", "missing"} {
+		if item, err := k.Get(state, slug); err != nil || item == nil || item.Slug != slug {
+			t.Errorf("%v: got %v, %v", slug, item, err)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package accords_mirrorrer
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"reflect"
+	"strings"
+
+	"git.vs49688.net/zane/accords-mirrorrer/library"
+)
+
+// The built-in kinds. Later kinds are found through earlier ones, so the order matters.
+var (
+	Folders = RegisterKind(&EntityKind[library.FolderProps]{
+		Name:  "folders",
+		Fetch: bySlug(library.Client.ListFolder),
+		Extract: func(item *library.FolderProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			for _, ct := range item.Contents {
+				addUploadImageFragment(ct.Thumbnail, ref.WithRole(RoleThumbnail), client, dst, log)
+			}
+		},
+	})
+
+	Library = RegisterKind(&EntityKind[library.LibraryProps]{
+		Name:  "library",
+		Fetch: bySlug(library.Client.GetLibraryItem),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			return searchEntity(ctx, log, "slug", client.SearchLibrary)
+		},
+		Extract: func(item *library.LibraryProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			extractLibraryItem(item.Item, ref, client, dst, log)
+		},
+	})
+
+	Reader = RegisterKind(&EntityKind[library.ReaderProps]{
+		Name:  "reader",
+		Fetch: bySlug(library.Client.GetReader),
+		// Only library items with scans have a reader.
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			items, err := Library.All(state)
+			if err != nil {
+				return nil, err
+			}
+
+			var slugs []string
+			for slug, item := range items {
+				if item.HasContentScans {
+					slugs = append(slugs, slug)
+				}
+			}
+
+			return slugs, nil
+		},
+		Extract: func(item *library.ReaderProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			extractLibraryItem(item.Item, ref, client, dst, log)
+		},
+	})
+
+	Wiki = RegisterKind(&EntityKind[library.WikiProps]{
+		Name:  "wiki",
+		Fetch: bySlug(library.Client.GetWikiPage),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			return searchEntity(ctx, log, "slug", client.SearchWiki)
+		},
+		Extract: func(item *library.WikiProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			addUploadFileEntityResponse(item.Page.Thumbnail, ref.WithRole(RoleThumbnail), client, dst, log)
+		},
+	})
+
+	WeaponStories = RegisterKind(&EntityKind[library.WeaponStoryProps]{
+		Name:  "weapon_stories",
+		Fetch: bySlug(library.Client.GetWeaponStory),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			return searchEntity(ctx, log, "slug", client.SearchWeaponStories)
+		},
+		Extract: func(item *library.WeaponStoryProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			extractWeaponStory(item.Weapon, ref, client, dst, log)
+		},
+	})
+
+	Chronology = RegisterKind(&EntityKind[library.ChronologyProps]{
+		Name:      "chronology",
+		Singleton: true,
+		Fetch: func(ctx context.Context, client library.Client, _ string) (*library.ChronologyProps, error) {
+			return client.GetChronology(ctx)
+		},
+	})
+
+	Posts = RegisterKind(&EntityKind[library.PostProps]{
+		Name:  "posts",
+		Fetch: bySlug(library.Client.GetPost),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			return searchEntity(ctx, log, "slug", client.SearchPosts)
+		},
+		Extract: func(item *library.PostProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			addUploadFileEntityResponse(item.Post.Thumbnail, ref.WithRole(RoleThumbnail), client, dst, log)
+
+			for _, tl := range item.Post.Translations {
+				addUploadFileEntityResponse(tl.Thumbnail, ref.WithRole(RoleThumbnail), client, dst, log)
+			}
+		},
+	})
+
+	ChroniclesIndex = RegisterKind(&EntityKind[library.ChroniclesProps]{
+		Name:      "chronicles_index",
+		Key:       "chronicles.index",
+		Singleton: true,
+		Fetch: func(ctx context.Context, client library.Client, _ string) (*library.ChroniclesProps, error) {
+			return client.GetChronicles(ctx)
+		},
+	})
+
+	Chronicles = RegisterKind(&EntityKind[library.ChronicleProps]{
+		Name:  "chronicles",
+		Key:   "chronicles.entries",
+		Fetch: bySlug(library.Client.GetChronicle),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			index, err := ChroniclesIndex.Get(state, "")
+			if err != nil || index == nil {
+				return nil, err
+			}
+
+			var slugs []string
+			for _, chapter := range index.Chapters {
+				if chapter.Attributes == nil || chapter.Attributes.Chronicles == nil {
+					continue
+				}
+
+				for _, chronicle := range chapter.Attributes.Chronicles.Data {
+					if chronicle.Attributes != nil {
+						slugs = append(slugs, chronicle.Attributes.Slug)
+					}
+				}
+			}
+
+			return slugs, nil
+		},
+		Extract: func(item *library.ChronicleProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			extractChronicle(&item.Chronicle, ref, client, dst, log)
+		},
+	})
+
+	// Content is found through the folders and chronicles.
+	Content = RegisterKind(&EntityKind[library.ContentProps]{
+		Name:  "content",
+		Fetch: bySlug(library.Client.GetContents),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			slugs := map[string]struct{}{}
+
+			folders, err := Folders.All(state)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range folders {
+				for _, ct := range f.Contents {
+					slugs[ct.Slug] = struct{}{}
+				}
+			}
+
+			chronicles, err := Chronicles.All(state)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ch := range chronicles {
+				if ch.Chronicle.Contents == nil {
+					continue
+				}
+
+				for _, ct := range ch.Chronicle.Contents.Data {
+					if ct.Attributes != nil {
+						slugs[ct.Attributes.Slug] = struct{}{}
+					}
+				}
+			}
+
+			return setToSlice(slugs), nil
+		},
+		Extract: func(item *library.ContentProps, ref Ref, client library.Client, dst *State, log *slog.Logger) {
+			extractContents(item.Content, ref, client, dst, log)
+		},
+	})
+
+	Videos = RegisterKind(&EntityKind[library.VideoProps]{
+		Name:  "videos",
+		Fetch: bySlug(library.Client.GetVideo),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			return searchEntity(ctx, log, "uid", client.SearchVideos)
+		},
+		Extract: extractVideo,
+	})
+
+	// There's no endpoint to list/search video channels that I can find, so infer them from the videos.
+	VideoChannels = RegisterKind(&EntityKind[library.VideoChannelProps]{
+		Name:  "video_channels",
+		Fetch: bySlug(library.Client.GetVideoChannel),
+		Search: func(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+			videos, err := Videos.All(state)
+			if err != nil {
+				return nil, err
+			}
+
+			uids := map[string]struct{}{}
+			for _, v := range videos {
+				if !strings.HasPrefix(v.Channel.Href, "/archives/videos/c/") {
+					continue
+				}
+
+				_, uid := path.Split(v.Channel.Href)
+				uids[uid] = struct{}{}
+			}
+
+			return setToSlice(uids), nil
+		},
+	})
+)
+
+func init() {
+	// Set here, as they refer to the kinds themselves, or ones registered after them.
+	Folders.Search = searchFolders
+	Folders.Relations = folderRelations
+	Library.Relations = libraryRelations
+	Reader.Relations = func(item *library.ReaderProps) []Ref {
+		return libraryItemContents(item.Item)
+	}
+	Chronicles.Relations = func(item *library.ChronicleProps) []Ref {
+		return contentRefs(item.Chronicle.Contents)
+	}
+}
+
+func folderRelations(item *library.FolderProps) []Ref {
+	var refs []Ref
+	for _, sf := range item.Subfolders {
+		refs = append(refs, Ref{Kind: Folders.Name, Slug: sf.Slug})
+	}
+
+	for _, ct := range item.Contents {
+		refs = append(refs, Ref{Kind: Content.Name, Slug: ct.Slug})
+	}
+
+	return refs
+}
+
+// libraryRelations returns the subitems, reader and contents of a library item.
+func libraryRelations(item *library.LibraryProps) []Ref {
+	if item.Item == nil {
+		return nil
+	}
+
+	var refs []Ref
+	if item.Item.Subitems != nil {
+		for _, sub := range item.Item.Subitems.Data {
+			if sub.Attributes != nil {
+				refs = append(refs, Ref{Kind: Library.Name, Slug: sub.Attributes.Slug})
+			}
+		}
+	}
+
+	if item.HasContentScans {
+		refs = append(refs, Ref{Kind: Reader.Name, Slug: item.Item.Slug})
+	}
+
+	return append(refs, libraryItemContents(item.Item)...)
+}
+
+func libraryItemContents(item *library.LibraryItem) []Ref {
+	if item == nil || item.Contents == nil {
+		return nil
+	}
+
+	var refs []Ref
+	for _, rc := range item.Contents.Data {
+		if rc.Attributes == nil || rc.Attributes.Content == nil || rc.Attributes.Content.Data == nil || rc.Attributes.Content.Data.Attributes == nil {
+			continue
+		}
+
+		refs = append(refs, Ref{Kind: Content.Name, Slug: rc.Attributes.Content.Data.Attributes.Slug})
+	}
+
+	return refs
+}
+
+func contentRefs(contents *library.ContentRelationResponseCollection) []Ref {
+	if contents == nil {
+		return nil
+	}
+
+	var refs []Ref
+	for _, ct := range contents.Data {
+		if ct.Attributes != nil {
+			refs = append(refs, Ref{Kind: Content.Name, Slug: ct.Attributes.Slug})
+		}
+	}
+
+	return refs
+}
+
+// searchFolders recursively scans the "folders", starting at the root.
+func searchFolders(ctx context.Context, state *State, client library.Client, log *slog.Logger) ([]string, error) {
+	queue := []string{"root"}
+	seen := map[string]struct{}{"root": {}}
+
+	for current := 0; current < len(queue); current += 1 {
+		slug := queue[current]
+
+		log.With(slog.String("slug", slug)).InfoContext(ctx, "fetching folder")
+
+		idx, err := Folders.Ensure(ctx, state, client, slug)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sf := range idx.Subfolders {
+			if _, exists := seen[sf.Slug]; !exists {
+				seen[sf.Slug] = struct{}{}
+				queue = append(queue, sf.Slug)
+			}
+		}
+	}
+
+	return queue, nil
+}
+
+// bySlug adapts a client method to EntityKind.Fetch.
+func bySlug[T any](fetch func(client library.Client, ctx context.Context, slug string) (*T, error)) func(context.Context, library.Client, string) (*T, error) {
+	return func(ctx context.Context, client library.Client, slug string) (*T, error) {
+		return fetch(client, ctx, slug)
+	}
+}
+
+func setToSlice(set map[string]struct{}) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+
+	return out
+}
+
+func searchEntity[T any](ctx context.Context, log *slog.Logger, idAttrib string, search func(ctx context.Context, page, hitsPerPage int, attributes []string) (*library.SearchResult[T], error)) ([]string, error) {
+	numPages := 1
+
+	slugs := map[string]struct{}{}
+
+	for i := 0; i < numPages; i += 1 {
+		log.With(slog.Int("page", i+1), slog.Int("hits_per_page", 25)).InfoContext(ctx, "searching")
+		sr, err := search(ctx, i+1, 25, []string{idAttrib})
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			numPages = sr.TotalPages
+		}
+
+		for _, hit := range sr.Hits {
+			// FIXME: bit hacky, I don't care anymore
+			var fieldName string
+			switch idAttrib {
+			case "slug":
+				fieldName = "Slug"
+			case "uid":
+				fieldName = "Uid"
+			default:
+				panic("unknown field name " + idAttrib)
+			}
+
+			slug := reflect.ValueOf(hit).Elem().FieldByName(fieldName).String()
+			slugs[slug] = struct{}{}
+		}
+	}
+
+	return setToSlice(slugs), nil
+}
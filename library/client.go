@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 )
 
 type libraryClient struct {
@@ -71,51 +72,61 @@ func (c *libraryClient) GetCMSUrl() *url.URL {
 	return &u
 }
 
-func fetch200_2(req *http.Request, client *http.Client, out any) ([]byte, error) {
+func fetch200_2(req *http.Request, client *http.Client, out any) ([]byte, http.Header, error) {
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %v", resp.Status)
+		return nil, nil, fmt.Errorf("HTTP %v", resp.Status)
 	}
 
 	raw, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := json.Unmarshal(raw, out); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return raw, err
+	return raw, resp.Header, err
 }
 
-func fetchEntity[T any](ctx context.Context, url string, client *http.Client) (*T, []byte, error) {
+func fetchEntity[T any](ctx context.Context, url string, client *http.Client) (*T, []byte, *FetchInfo, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	type rawEnvelope struct {
 		PageProps json.RawMessage `json:"pageProps"`
 	}
 
+	fetchedAt := time.Now().UTC()
+
 	raw := rawEnvelope{}
-	if _, err := fetch200_2(req, client, &raw); err != nil {
-		return nil, nil, err
+	_, header, err := fetch200_2(req, client, &raw)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	val := new(T)
 	if err := json.Unmarshal(raw.PageProps, val); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+
+	info := &FetchInfo{
+		URL:          url,
+		FetchedAt:    fetchedAt,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
 	}
 
-	return val, raw.PageProps, nil
+	return val, raw.PageProps, info, nil
 }
 
 func (c *libraryClient) GetLibraryItem(ctx context.Context, slug string) (*LibraryProps, error) {
@@ -125,12 +136,13 @@ func (c *libraryClient) GetLibraryItem(ctx context.Context, slug string) (*Libra
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[LibraryProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[LibraryProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -141,12 +153,13 @@ func (c *libraryClient) GetReader(ctx context.Context, slug string) (*ReaderProp
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[ReaderProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[ReaderProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -157,12 +170,13 @@ func (c *libraryClient) ListFolder(ctx context.Context, slug string) (*FolderPro
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[FolderProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[FolderProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -173,12 +187,13 @@ func (c *libraryClient) GetContents(ctx context.Context, slug string) (*ContentP
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[ContentProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[ContentProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -256,12 +271,13 @@ func (c *libraryClient) GetWikiPage(ctx context.Context, slug string) (*WikiProp
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[WikiProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[WikiProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -279,12 +295,13 @@ func (c *libraryClient) GetWeaponStory(ctx context.Context, slug string) (*Weapo
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[WeaponStoryProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[WeaponStoryProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -301,12 +318,13 @@ func (c *libraryClient) GetChronology(ctx context.Context) (*ChronologyProps, er
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[ChronologyProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[ChronologyProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -317,12 +335,13 @@ func (c *libraryClient) GetPost(ctx context.Context, slug string) (*PostProps, e
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[PostProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[PostProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -340,12 +359,13 @@ func (c *libraryClient) GetChronicle(ctx context.Context, slug string) (*Chronic
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[ChronicleProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[ChronicleProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -355,12 +375,13 @@ func (c *libraryClient) GetChronicles(ctx context.Context) (*ChroniclesProps, er
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[ChroniclesProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[ChroniclesProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -371,12 +392,13 @@ func (c *libraryClient) GetVideo(ctx context.Context, uid string) (*VideoProps,
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[VideoProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[VideoProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
 
@@ -394,11 +416,12 @@ func (c *libraryClient) GetVideoChannel(ctx context.Context, uid string) (*Video
 
 	us := u.String()
 
-	val, raw, err := fetchEntity[VideoChannelProps](ctx, us, c.client)
+	val, raw, info, err := fetchEntity[VideoChannelProps](ctx, us, c.client)
 	if err != nil {
 		return nil, err
 	}
 
 	val.Raw = raw
+	val.Fetch = info
 	return val, err
 }
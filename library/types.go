@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/url"
+	"time"
 )
 
 type UploadImageFragment struct {
@@ -32,6 +33,16 @@ type OpenGraph struct {
 
 type Props struct {
 	OpenGraph OpenGraph `json:"openGraph"`
+
+	Fetch *FetchInfo `json:"-"`
+}
+
+// FetchInfo records where and when an entity was fetched from.
+type FetchInfo struct {
+	URL          string
+	FetchedAt    time.Time
+	ETag         string
+	LastModified string
 }
 
 type Track struct {
@@ -0,0 +1,214 @@
+package accords_mirrorrer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DirLockName is the name of the lock file in an output directory.
+const DirLockName = ".accords-mirrorrer.lock"
+
+// staleLockAge is how old an unreadable lock file must be before it's considered stale.
+// It may just be in the middle of being written.
+const staleLockAge = time.Minute
+
+var ErrLocked = errors.New("locked")
+
+// LockInfo identifies the holder of a lock.
+type LockInfo struct {
+	PID        int       `json:"pid"`
+	Host       string    `json:"host"`
+	Command    string    `json:"command,omitempty"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+func (i *LockInfo) String() string {
+	s := fmt.Sprintf("pid %d on %v", i.PID, i.Host)
+	if i.Command != "" {
+		s += fmt.Sprintf(" (%v)", i.Command)
+	}
+
+	return s + fmt.Sprintf(", since %v", i.AcquiredAt.Format(time.RFC3339))
+}
+
+// LockedError is returned when a lock is held by another process.
+type LockedError struct {
+	Path   string
+	Holder *LockInfo
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%v is locked by an unknown process, remove it if nothing else is running", e.Path)
+	}
+
+	return fmt.Sprintf("%v is locked by %v, remove it if that process is no longer running", e.Path, e.Holder)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Lock is an advisory lock file.
+type Lock struct {
+	path string
+	info LockInfo
+}
+
+// StateLockPath returns the path of the lock file of a state file.
+func StateLockPath(statePath string) string {
+	return statePath + ".lock"
+}
+
+// LockState locks a state file against modification by other processes.
+func LockState(statePath string, command string) (*Lock, error) {
+	return AcquireLock(StateLockPath(statePath), command)
+}
+
+// LockDir locks an output directory against modification by other processes.
+func LockDir(dir string, command string) (*Lock, error) {
+	return AcquireLock(filepath.Join(dir, DirLockName), command)
+}
+
+// ReadLock returns the holder of a lock file, or nil if it isn't locked.
+func ReadLock(path string) (*LockInfo, error) {
+	b, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	info := &LockInfo{}
+	if err := json.Unmarshal(b, info); err != nil {
+		return nil, fmt.Errorf("invalid lock file %v: %w", path, err)
+	}
+
+	return info, nil
+}
+
+// isStale returns whether the lock file at path was left behind by a process that no longer exists.
+// Locks held on other hosts are never stale, as there's no way to tell.
+func isStale(path string, info *LockInfo) bool {
+	if info == nil {
+		fi, err := os.Stat(path)
+		return err == nil && time.Since(fi.ModTime()) > staleLockAge
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host != info.Host {
+		return false
+	}
+
+	return info.PID != os.Getpid() && !processExists(info.PID)
+}
+
+// AcquireLock creates the lock file at path, replacing it if it's stale.
+func AcquireLock(path string, command string) (*Lock, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lock{
+		path: path,
+		info: LockInfo{
+			PID:        os.Getpid(),
+			Host:       host,
+			Command:    command,
+			AcquiredAt: time.Now().UTC(),
+		},
+	}
+
+	b, err := json.Marshal(l.info)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt += 1 {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = f.Write(b)
+			if err2 := f.Close(); err == nil {
+				err = err2
+			}
+
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, err
+			}
+
+			return l, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		holder, _ := ReadLock(path)
+		if attempt > 0 || !isStale(path, holder) {
+			return nil, &LockedError{Path: path, Holder: holder}
+		}
+
+		if err := removeStale(path, holder); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sameHolder returns whether two lock files were written by the same acquisition.
+func sameHolder(a, b *LockInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.PID == b.PID && a.Host == b.Host && a.AcquiredAt.Equal(b.AcquiredAt)
+}
+
+// removeStale removes a stale lock file, as long as it's still held by the stale holder. It's renamed
+// aside first, so a lock acquired by another process in the meantime is put back rather than removed.
+func removeStale(path string, stale *LockInfo) error {
+	aside := fmt.Sprintf("%v.stale-%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if holder, _ := ReadLock(aside); !sameHolder(holder, stale) {
+		if err := os.Rename(aside, path); err != nil {
+			return err
+		}
+
+		return &LockedError{Path: path, Holder: holder}
+	}
+
+	return os.Remove(aside)
+}
+
+// Path returns the path of the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Release removes the lock file, if it's still ours.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	holder, err := ReadLock(l.path)
+	if err != nil {
+		return err
+	}
+
+	if !sameHolder(holder, &l.info) {
+		return fmt.Errorf("lock file %v was replaced by another process", l.path)
+	}
+
+	return os.Remove(l.path)
+}
@@ -0,0 +1,138 @@
+package accords_mirrorrer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// deadPID is assumed not to be a running process.
+const deadPID = 0x3ffffff0
+
+func writeLock(t *testing.T, path string, info LockInfo) {
+	t.Helper()
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		holder *LockInfo
+		locked bool
+	}{
+		{name: "unlocked"},
+		{name: "stale", holder: &LockInfo{PID: deadPID, Host: host, AcquiredAt: since}},
+		{name: "held by us", holder: &LockInfo{PID: os.Getpid(), Host: host, AcquiredAt: since}, locked: true},
+		{name: "other host", holder: &LockInfo{PID: deadPID, Host: host + ".other", AcquiredAt: since}, locked: true},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "state.json.lock")
+
+		if tt.holder != nil {
+			writeLock(t, path, *tt.holder)
+		}
+
+		l, err := AcquireLock(path, "test")
+		if tt.locked {
+			var le *LockedError
+			if !errors.As(err, &le) || !sameHolder(le.Holder, tt.holder) {
+				t.Errorf("%v: expected to be locked by %v, got %v", tt.name, tt.holder, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if holder, err := ReadLock(path); err != nil || !sameHolder(holder, &l.info) {
+			t.Errorf("%v: lock file holds %v, %v", tt.name, holder, err)
+		}
+
+		if err := l.Release(); err != nil {
+			t.Errorf("%v: release: %v", tt.name, err)
+		}
+
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%v: %v files left behind", tt.name, len(entries))
+		}
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json.lock")
+
+	stale := &LockInfo{PID: deadPID, Host: host, AcquiredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	fresh := LockInfo{PID: os.Getpid(), Host: host, AcquiredAt: time.Now().UTC()}
+
+	// Another process replaced the stale lock after it was read.
+	writeLock(t, path, fresh)
+
+	var le *LockedError
+	if err := removeStale(path, stale); !errors.As(err, &le) || !sameHolder(le.Holder, &fresh) {
+		t.Errorf("expected to be locked by %v, got %v", &fresh, err)
+	}
+
+	if holder, err := ReadLock(path); err != nil || !sameHolder(holder, &fresh) {
+		t.Errorf("the fresh lock wasn't put back: %v, %v", holder, err)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%v files, want just the lock", len(entries))
+	}
+
+	writeLock(t, path, *stale)
+	if err := removeStale(path, stale); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%v files left behind", len(entries))
+	}
+}
+
+func TestReleaseReplacedLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json.lock")
+
+	l, err := AcquireLock(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := l.info
+	other.AcquiredAt = other.AcquiredAt.Add(time.Second)
+	writeLock(t, path, other)
+
+	if err := l.Release(); err == nil {
+		t.Error("released a lock that was replaced")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the replacing lock was removed: %v", err)
+	}
+}
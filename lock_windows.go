@@ -0,0 +1,32 @@
+//go:build windows
+
+package accords_mirrorrer
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+// processExists opens the process to check it hasn't exited. os.FindProcess can't tell, as it
+// succeeds for any pid. A process we may not open exists.
+func processExists(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if errors.Is(err, syscall.ERROR_ACCESS_DENIED) {
+		return true
+	} else if err != nil {
+		return false
+	}
+	defer func() { _ = syscall.CloseHandle(h) }()
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+
+	return code == stillActive
+}
@@ -0,0 +1,332 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cavaliergopher/grab/v3"
+
+	"git.vs49688.net/zane/goutils/download"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// pendingDownloads returns the downloads to attempt, in the configured order. Those that are gone,
+// or have failed recently, are skipped unless the options say otherwise. Only the selected downloads
+// are considered after ExtractOnly.
+func (m *Mirror) pendingDownloads(ctx context.Context) []*accords_mirrorrer.Download {
+	now := time.Now()
+
+	var (
+		pending     []*accords_mirrorrer.Download
+		numGone     int
+		numBackoff  int
+		numFiltered int
+		skipped     = map[string]int{}
+	)
+
+	for u, di := range m.state.Downloads {
+		if _, selected := m.only[u]; m.only != nil && !selected {
+			continue
+		}
+
+		if reason := m.filter.Skips(di); !di.Completed && reason != "" {
+			numFiltered += 1
+			skipped[reason] += 1
+			continue
+		}
+
+		switch di.Status() {
+		case accords_mirrorrer.StatusCompleted:
+			continue
+		case accords_mirrorrer.StatusGone:
+			if !m.opts.RetryGone {
+				numGone += 1
+				continue
+			}
+		case accords_mirrorrer.StatusFailed:
+			if !m.opts.RetryFailed && di.NextAttempt().After(now) {
+				numBackoff += 1
+				continue
+			}
+		}
+
+		pending = append(pending, di)
+	}
+
+	if numFiltered > 0 {
+		m.log.With(
+			slog.String("profile", m.opts.Profile),
+			slog.Int("filtered", numFiltered),
+			slog.Any("reasons", skipped),
+		).InfoContext(ctx, "skipping downloads excluded by the profile")
+	}
+
+	if numGone > 0 || numBackoff > 0 {
+		m.log.With(
+			slog.Int("gone", numGone),
+			slog.Int("backing_off", numBackoff),
+		).InfoContext(ctx, "skipping failed downloads")
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].URL < pending[j].URL
+	})
+
+	m.order(pending)
+
+	return pending
+}
+
+// httpStatus returns the HTTP status of a failed download, or 0 if there wasn't a response.
+func httpStatus(err error) int {
+	var sce grab.StatusCodeError
+	if errors.As(err, &sce) {
+		return int(sce)
+	}
+
+	return 0
+}
+
+// downloadOne downloads into a copy of d, so the state can be saved while it's in progress.
+func (m *Mirror) downloadOne(ctx context.Context, d *accords_mirrorrer.Download) error {
+	m.mu.Lock()
+	di := d.DownloadInfo
+	m.mu.Unlock()
+
+	di.OutPath = filepath.Join(m.opts.Dir, filepath.FromSlash(di.OutPath))
+
+	req, err := download.MakeRequest(ctx, &di, m.log)
+	if err != nil {
+		return err
+	}
+
+	hc, v := recordValidators(m.hc)
+	err = download.RunOne(ctx, hc, req, m.log)
+
+	if err == nil && v.lastModified != "" {
+		if err := setModTime(di.OutPath, v.lastModified); err != nil {
+			m.log.With(slog.String("out_path", di.OutPath), slog.Any("error", err)).WarnContext(ctx, "error setting modification time")
+		}
+	}
+
+	m.mu.Lock()
+	d.Size = di.Size
+	d.SHA256 = di.SHA256
+	d.Completed = di.Completed
+
+	// Being interrupted or running out of space isn't the download's fault.
+	if err == nil {
+		d.ClearFailures()
+		d.ETag = v.etag
+		d.LastModified = v.lastModified
+	} else if ctx.Err() == nil && !errors.Is(err, syscall.ENOSPC) {
+		d.RecordFailure(err, httpStatus(err), time.Now().UTC())
+	}
+	m.mu.Unlock()
+
+	return err
+}
+
+var (
+	errMaxBytes     = errors.New("byte quota reached")
+	errStopAfter    = errors.New("time limit reached")
+	errWindowClosed = errors.New("download window closed")
+)
+
+// timerAt returns a channel that fires at t, or never if t is zero.
+func timerAt(t time.Time) (<-chan time.Time, func()) {
+	if t.IsZero() {
+		return nil, func() {}
+	}
+
+	timer := time.NewTimer(time.Until(t))
+	return timer.C, func() { timer.Stop() }
+}
+
+// sessionDeadline returns when the session ends, or zero if it doesn't.
+func (m *Mirror) sessionDeadline() time.Time {
+	if m.opts.StopAfter <= 0 {
+		return time.Time{}
+	}
+
+	return m.started.Add(m.opts.StopAfter)
+}
+
+// waitForWindow waits until a download window is open, returning when it closes.
+func (m *Mirror) waitForWindow(ctx context.Context) (time.Time, error) {
+	opens, closes := nextWindow(m.opts.Windows, time.Now())
+	if !opens.After(time.Now()) {
+		return closes, nil
+	}
+
+	m.log.With(slog.Time("opens_at", opens)).InfoContext(ctx, "waiting for download window")
+
+	open, stopOpen := timerAt(opens)
+	defer stopOpen()
+
+	deadline, stopDeadline := timerAt(m.sessionDeadline())
+	defer stopDeadline()
+
+	select {
+	case <-open:
+		return closes, nil
+	case <-deadline:
+		return time.Time{}, errStopAfter
+	case <-m.stop:
+		return time.Time{}, ErrStopped
+	case <-ctx.Done():
+		return time.Time{}, ctx.Err()
+	}
+}
+
+// Download downloads everything that hasn't completed. Individual failures are logged and left pending.
+// Unless disabled, the downloads are sized first, and it fails with ErrInsufficientSpace if they won't fit.
+// Cancelling the context aborts the downloads in progress, see Stop to let them finish.
+// If a quota is reached or the download window closes, the downloads in progress are aborted
+// and it returns without error, so the state can be saved.
+func (m *Mirror) Download(ctx context.Context) error {
+	closes, err := m.waitForWindow(ctx)
+	if errors.Is(err, errStopAfter) {
+		m.log.With(slog.Any("reason", err)).InfoContext(ctx, "stopping downloads")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	pending := m.pendingDownloads(ctx)
+
+	if !m.opts.DontPreflight {
+		numSized, err := m.sizeDownloads(ctx, pending)
+		if err != nil {
+			return err
+		}
+
+		// The new sizes may exclude downloads, or change their order.
+		if numSized > 0 {
+			pending = m.pendingDownloads(ctx)
+		}
+
+		if err := m.checkFreeSpace(ctx, pending); err != nil {
+			return err
+		}
+	}
+
+	if hook := m.opts.Hooks.DownloadQueued; hook != nil {
+		for _, d := range pending {
+			hook(ctx, d)
+		}
+	}
+
+	// Reaching a limit aborts everything, but the queue is cancelled separately,
+	// so Stop and running out of space let the current downloads finish.
+	limitCtx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+
+	queueCtx, cancelQueue := context.WithCancel(limitCtx)
+	defer cancelQueue()
+
+	go func() {
+		deadline, stopDeadline := timerAt(m.sessionDeadline())
+		defer stopDeadline()
+
+		windowClosed, stopWindow := timerAt(closes)
+		defer stopWindow()
+
+		select {
+		case <-m.stop:
+			cancelQueue()
+		case <-m.meter.exhausted:
+			abort(errMaxBytes)
+		case <-deadline:
+			abort(errStopAfter)
+		case <-windowClosed:
+			abort(errWindowClosed)
+		case <-queueCtx.Done():
+		}
+	}()
+
+	var (
+		outOfSpace   error
+		numCompleted int
+		numFailed    int
+	)
+
+	run := func(d *accords_mirrorrer.Download) {
+		err := m.downloadOne(limitCtx, d)
+
+		if hook := m.opts.Hooks.DownloadFinished; hook != nil {
+			hook(ctx, d, err)
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if err == nil {
+			numCompleted += 1
+		} else if limitCtx.Err() == nil {
+			numFailed += 1
+		}
+
+		// Abort if out-of-space.
+		if errors.Is(err, syscall.ENOSPC) {
+			m.log.With(slog.String("url", d.URL), slog.Any("error", err)).ErrorContext(ctx, "out of disk space, cancelling")
+			outOfSpace = err
+			cancelQueue()
+		}
+	}
+
+	if err := m.setDownloading(true); err != nil {
+		m.log.With(slog.Any("error", err)).ErrorContext(ctx, "error saving state")
+	}
+	defer func() { _ = m.setDownloading(false) }()
+
+	parallelism := m.opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	sched := newScheduler(pending, m.opts.HostParallelism)
+
+	wg := sync.WaitGroup{}
+	for range parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for d := sched.next(queueCtx); d != nil; d = sched.next(queueCtx) {
+				run(d)
+				sched.done(d)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	m.log.With(
+		slog.Int("pending", len(pending)),
+		slog.Int("completed", numCompleted),
+		slog.Int("failed", numFailed),
+		slog.Int64("received_bytes", m.meter.received.Load()),
+	).InfoContext(ctx, "downloads finished")
+
+	switch {
+	case outOfSpace != nil:
+		return outOfSpace
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case limitCtx.Err() != nil:
+		m.log.With(slog.Any("reason", context.Cause(limitCtx))).InfoContext(ctx, "stopped downloads")
+	case m.stopped():
+		return ErrStopped
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package mirror
+
+import (
+	"fmt"
+	"log/slog"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+	"git.vs49688.net/zane/accords-mirrorrer/library"
+)
+
+// extractInto adds the downloads of every entity of state, and the scrape results, to dst.
+func extractInto(state *accords_mirrorrer.State, client library.Client, dst *accords_mirrorrer.State, l *slog.Logger) error {
+	raw := state.RawEntities()
+
+	for _, k := range accords_mirrorrer.Kinds() {
+		for slug := range raw[k.GetName()] {
+			if err := k.ExtractDownloads(state, slug, client, dst, l); err != nil {
+				return fmt.Errorf("error decoding %v entity %q: %w", k.GetName(), slug, err)
+			}
+		}
+	}
+
+	scrapeRef := accords_mirrorrer.Ref{Kind: accords_mirrorrer.KindScrape, Role: accords_mirrorrer.RoleScrape}
+	for _, u := range state.Scraped {
+		if _, err := dst.AddDownload(u, scrapeRef); err != nil {
+			l.With(slog.Any("error", err), slog.String("url", u)).Warn("error adding scraped url")
+		}
+	}
+
+	return nil
+}
+
+// extractAll adds the downloads of every entity, rebuilding the references from scratch.
+func extractAll(state *accords_mirrorrer.State, client library.Client, l *slog.Logger) error {
+	state.ClearRefs()
+	return extractInto(state, client, state, l)
+}
+
+// UpdateRefs rebuilds the download references from the entities, adding any missing downloads.
+func UpdateRefs(state *accords_mirrorrer.State, l *slog.Logger) error {
+	return extractAll(state, library.NewClient(nil), l)
+}
+
+// ReachableDownloads returns the URLs of the downloads referenced by the entities and the last scrape.
+// The state isn't modified.
+func ReachableDownloads(state *accords_mirrorrer.State, l *slog.Logger) (map[string]struct{}, error) {
+	dst := accords_mirrorrer.NewState()
+	if err := extractInto(state, library.NewClient(nil), dst, l); err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[string]struct{}, len(dst.Downloads))
+	for u := range dst.Downloads {
+		reachable[u] = struct{}{}
+	}
+
+	return reachable, nil
+}
+
+// Extract adds the downloads of every entity, rebuilding the references from scratch.
+func (m *Mirror) Extract() error {
+	return extractAll(m.state, m.client, m.log)
+}
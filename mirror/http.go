@@ -0,0 +1,111 @@
+package mirror
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// HTTPOptions configure the HTTP client used for every request: the index, the scrape and the downloads.
+type HTTPOptions struct {
+	// UserAgent is sent with every request. Defaults to accords_mirrorrer.UserAgent.
+	UserAgent string
+
+	// Proxy is an http, https, socks5 or socks5h proxy URL.
+	// If nil, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+	Proxy *url.URL
+
+	// CABundle is the path of a PEM file of extra CAs to trust, in addition to the system's.
+	CABundle string
+
+	// ConnectTimeout limits establishing a connection, and the TLS handshake. 0 for the default.
+	ConnectTimeout time.Duration
+
+	// ResponseTimeout limits waiting for the response headers after sending a request. 0 for none.
+	// There's no limit on reading the body, as the downloads may be large.
+	ResponseTimeout time.Duration
+
+	// MaxConnsPerHost limits the connections to each host, including ones in use. 0 for no limit.
+	MaxConnsPerHost int
+
+	// MaxIdleConnsPerHost is the number of idle connections kept for each host. 0 for the default.
+	MaxIdleConnsPerHost int
+}
+
+type transport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+func (t transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(request)
+}
+
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %v", path)
+	}
+
+	return pool, nil
+}
+
+// NewHTTPClient builds a client from the options.
+func NewHTTPClient(opts HTTPOptions) (*http.Client, error) {
+	if opts.UserAgent == "" {
+		opts.UserAgent = accords_mirrorrer.UserAgent
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != nil {
+		switch opts.Proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https, socks5 or socks5h", opts.Proxy.Scheme)
+		}
+
+		t.Proxy = http.ProxyURL(opts.Proxy)
+	}
+
+	if opts.CABundle != "" {
+		pool, err := loadCABundle(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("error loading ca bundle: %w", err)
+		}
+
+		t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	if opts.ConnectTimeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = opts.ConnectTimeout
+	}
+
+	t.ResponseHeaderTimeout = opts.ResponseTimeout
+	t.MaxConnsPerHost = opts.MaxConnsPerHost
+
+	if opts.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+
+	return &http.Client{Transport: transport{userAgent: opts.UserAgent, base: t}}, nil
+}
@@ -0,0 +1,238 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by every request, allowing a burst of a second's worth.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// burst is the most that should be read at once.
+func (l *rateLimiter) burst() int {
+	return max(int(l.rate), 4096)
+}
+
+// wait takes n bytes from the bucket, sleeping until they've been paid for.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+
+	if debt >= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(time.Duration(-debt / l.rate * float64(time.Second)))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// meter counts and throttles the bytes received by every request.
+type meter struct {
+	received atomic.Int64
+	limiter  *rateLimiter
+
+	// maxBytes is closed once more than maxBytes have been received, if it's >0.
+	maxBytes  int64
+	exhausted chan struct{}
+	once      sync.Once
+}
+
+func newMeter(maxRate, maxBytes int64) *meter {
+	m := &meter{maxBytes: maxBytes, exhausted: make(chan struct{})}
+	if maxRate > 0 {
+		m.limiter = newRateLimiter(maxRate)
+	}
+
+	return m
+}
+
+func (m *meter) add(n int) {
+	if total := m.received.Add(int64(n)); m.maxBytes > 0 && total >= m.maxBytes {
+		m.once.Do(func() { close(m.exhausted) })
+	}
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	meter *meter
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	if b.meter.limiter != nil && len(p) > b.meter.limiter.burst() {
+		p = p[:b.meter.limiter.burst()]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.meter.add(n)
+
+		if b.meter.limiter != nil {
+			if werr := b.meter.limiter.wait(b.ctx, n); werr != nil && err == nil {
+				err = werr
+			}
+		}
+	}
+
+	return n, err
+}
+
+type meteredTransport struct {
+	base  http.RoundTripper
+	meter *meter
+}
+
+func (t meteredTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &meteredBody{ReadCloser: resp.Body, ctx: request.Context(), meter: t.meter}
+	return resp, nil
+}
+
+// meteredClient returns a copy of hc that reports to m.
+func meteredClient(hc *http.Client, m *meter) *http.Client {
+	c := *hc
+	if c.Transport == nil {
+		c.Transport = http.DefaultTransport
+	}
+
+	c.Transport = meteredTransport{base: c.Transport, meter: m}
+	return &c
+}
+
+// Window is a daily time window, in local time. It may span midnight.
+type Window struct {
+	// Start and End are offsets from midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+var clockPattern = regexp.MustCompile(`^([0-9]{1,2}):([0-9]{2})$`)
+
+// parseClock parses a time of day in the form "HH:MM" as an offset from midnight. 24:00 is the end of the day.
+func parseClock(s string) (time.Duration, bool) {
+	parts := clockPattern.FindStringSubmatch(s)
+	if parts == nil {
+		return 0, false
+	}
+
+	h, _ := strconv.Atoi(parts[1])
+	m, _ := strconv.Atoi(parts[2])
+	if h > 24 || m > 59 || (h == 24 && m != 0) {
+		return 0, false
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, true
+}
+
+// ParseWindow parses a window in the form "22:00-06:00".
+func ParseWindow(s string) (Window, error) {
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		return Window{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+	}
+
+	startAt, startOK := parseClock(start)
+	endAt, endOK := parseClock(end)
+	if !startOK || !endOK {
+		return Window{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+	}
+
+	// A window starting at the end of the day starts at midnight.
+	w := Window{Start: startAt % (24 * time.Hour), End: endAt}
+
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("invalid window %q, it's empty", s)
+	}
+
+	return w, nil
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d",
+		int(w.Start.Hours()), int(w.Start.Minutes())%60,
+		int(w.End.Hours()), int(w.End.Minutes())%60,
+	)
+}
+
+// next returns when the window next opens and closes, relative to t.
+// If t is within the window, opens is before t.
+func (w Window) next(t time.Time) (opens time.Time, closes time.Time) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	// Start from yesterday's window, in case it spans midnight.
+	for day := -1; ; day += 1 {
+		base := midnight.AddDate(0, 0, day)
+		opens = base.Add(w.Start)
+		closes = base.Add(w.End)
+
+		if w.End < w.Start {
+			closes = closes.AddDate(0, 0, 1)
+		}
+
+		if closes.After(t) {
+			return opens, closes
+		}
+	}
+}
+
+// nextWindow returns when the windows next open and close, relative to t. If t is within
+// several, it closes with the last of them. With no windows, it's always open.
+func nextWindow(windows []Window, t time.Time) (opens time.Time, closes time.Time) {
+	if len(windows) == 0 {
+		return t, time.Time{}
+	}
+
+	found, open := false, false
+	for _, w := range windows {
+		o, c := w.next(t)
+
+		if !o.After(t) {
+			if !open || c.After(closes) {
+				opens, closes = o, c
+			}
+			found, open = true, true
+		} else if !found || (!open && o.Before(opens)) {
+			opens, closes = o, c
+			found = true
+		}
+	}
+
+	return opens, closes
+}
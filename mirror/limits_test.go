@@ -0,0 +1,175 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		s     string
+		start time.Duration
+		end   time.Duration
+		err   bool
+	}{
+		{s: "22:00-06:00", start: 22 * time.Hour, end: 6 * time.Hour},
+		{s: "9:30-17:45", start: 9*time.Hour + 30*time.Minute, end: 17*time.Hour + 45*time.Minute},
+		{s: "00:00-24:00", start: 0, end: 24 * time.Hour},
+		{s: "24:00-06:00", start: 0, end: 6 * time.Hour},
+
+		{s: "", err: true},
+		{s: "22:00", err: true},
+		{s: "22:00-06:00x", err: true},
+		{s: "22:00-06:00 ", err: true},
+		{s: " 22:00-06:00", err: true},
+		{s: "22:00-06:00-07:00", err: true},
+		{s: "22:00-", err: true},
+		{s: "22-06", err: true},
+		{s: "22:0-06:00", err: true},
+		{s: "+1:00-06:00", err: true},
+		{s: "25:00-06:00", err: true},
+		{s: "24:30-06:00", err: true},
+		{s: "22:00-24:01", err: true},
+		{s: "22:60-06:00", err: true},
+		{s: "06:00-06:00", err: true},
+		{s: "00:00-24:00x", err: true},
+		{s: "24:00-00:00", err: true},
+	}
+
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.s)
+
+		switch {
+		case tt.err:
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tt.s, w)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error: %v", tt.s, err)
+		case w.Start != tt.start || w.End != tt.end:
+			t.Errorf("%q = %v-%v, want %v-%v", tt.s, w.Start, w.End, tt.start, tt.end)
+		}
+	}
+}
+
+func TestWindowString(t *testing.T) {
+	for _, s := range []string{"22:00-06:00", "09:30-17:45", "00:00-24:00"} {
+		w, err := ParseWindow(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if w.String() != s {
+			t.Errorf("%q.String() = %q", s, w.String())
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	parse := func(ss ...string) []Window {
+		var windows []Window
+		for _, s := range ss {
+			w, err := ParseWindow(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			windows = append(windows, w)
+		}
+
+		return windows
+	}
+
+	tests := []struct {
+		name    string
+		windows []Window
+		t       time.Time
+		opens   time.Time
+		closes  time.Time
+	}{
+		{
+			name:    "before a daytime window",
+			windows: parse("09:00-17:00"),
+			t:       at(10, 8, 0),
+			opens:   at(10, 9, 0),
+			closes:  at(10, 17, 0),
+		},
+		{
+			name:    "within a daytime window",
+			windows: parse("09:00-17:00"),
+			t:       at(10, 12, 0),
+			opens:   at(10, 9, 0),
+			closes:  at(10, 17, 0),
+		},
+		{
+			name:    "after a daytime window",
+			windows: parse("09:00-17:00"),
+			t:       at(10, 17, 0),
+			opens:   at(11, 9, 0),
+			closes:  at(11, 17, 0),
+		},
+		{
+			name:    "before midnight, within a window past midnight",
+			windows: parse("22:00-06:00"),
+			t:       at(10, 23, 0),
+			opens:   at(10, 22, 0),
+			closes:  at(11, 6, 0),
+		},
+		{
+			name:    "after midnight, within a window past midnight",
+			windows: parse("22:00-06:00"),
+			t:       at(11, 2, 0),
+			opens:   at(10, 22, 0),
+			closes:  at(11, 6, 0),
+		},
+		{
+			name:    "outside a window past midnight",
+			windows: parse("22:00-06:00"),
+			t:       at(11, 12, 0),
+			opens:   at(11, 22, 0),
+			closes:  at(12, 6, 0),
+		},
+		{
+			name:    "all day",
+			windows: parse("00:00-24:00"),
+			t:       at(10, 12, 0),
+			opens:   at(10, 0, 0),
+			closes:  at(11, 0, 0),
+		},
+		{
+			name:    "the earliest of several",
+			windows: parse("20:00-21:00", "13:00-14:00", "18:00-19:00"),
+			t:       at(10, 12, 0),
+			opens:   at(10, 13, 0),
+			closes:  at(10, 14, 0),
+		},
+		{
+			name:    "within overlapping windows",
+			windows: parse("22:00-02:00", "01:00-04:00"),
+			t:       at(11, 1, 30),
+			opens:   at(11, 1, 0),
+			closes:  at(11, 4, 0),
+		},
+		{
+			name:    "within one of several",
+			windows: parse("01:00-02:00", "12:00-13:00"),
+			t:       at(10, 12, 30),
+			opens:   at(10, 12, 0),
+			closes:  at(10, 13, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		opens, closes := nextWindow(tt.windows, tt.t)
+		if !opens.Equal(tt.opens) || !closes.Equal(tt.closes) {
+			t.Errorf("%v: %v-%v, want %v-%v", tt.name, opens, closes, tt.opens, tt.closes)
+		}
+	}
+
+	if opens, closes := nextWindow(nil, at(10, 12, 0)); !opens.Equal(at(10, 12, 0)) || !closes.IsZero() {
+		t.Errorf("no windows: %v-%v, want always open", opens, closes)
+	}
+}
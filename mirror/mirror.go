@@ -0,0 +1,390 @@
+// Package mirror drives a crawl of the library: refreshing the index, extracting the downloads
+// it references and downloading them. The accords-mirrorrer archive command is a thin wrapper over it.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+	"git.vs49688.net/zane/accords-mirrorrer/library"
+)
+
+// ErrStopped is returned when the mirror was stopped by Stop.
+var ErrStopped = errors.New("stopped")
+
+// Hooks are called as the mirror progresses. Any of them may be nil.
+// The download hooks are called concurrently, and must not modify the state.
+type Hooks struct {
+	// EntityFetched is called after an entity is fetched and stored.
+	EntityFetched accords_mirrorrer.EntityFetchedFunc
+
+	// DownloadQueued is called for each pending download before any are started.
+	DownloadQueued func(ctx context.Context, d *accords_mirrorrer.Download)
+
+	// DownloadFinished is called after each download that was started, successful or not.
+	DownloadFinished func(ctx context.Context, d *accords_mirrorrer.Download, err error)
+}
+
+type Options struct {
+	// StateFile is the path of the state file. Defaults to "state.json".
+	StateFile string
+
+	// Dir is the directory the downloads are saved to. Defaults to the working directory.
+	Dir string
+
+	// Parallelism is the number of concurrent downloads, <1 for GOMAXPROCS.
+	Parallelism int
+
+	// HostParallelism limits the concurrent downloads from each host, within Parallelism.
+	// DefaultHost applies to hosts without their own limit. Hosts without any limit only
+	// have Parallelism.
+	HostParallelism map[string]int
+
+	// DontRefresh skips the index refresh in Run, only downloading what we've got.
+	DontRefresh bool
+
+	// RefetchAfter fetches the entities that were fetched longer than this ago again during the
+	// refresh, recording their changes in the history. 0 to never fetch them again.
+	RefetchAfter time.Duration
+
+	// DontDownload skips the downloads in Run, only refreshing the index.
+	DontDownload bool
+
+	// DontPreflight skips sizing the pending downloads and checking they'll fit before downloading.
+	DontPreflight bool
+
+	// WarnFreeSpace only warns if the pending downloads won't fit in the free space, rather than
+	// failing with ErrInsufficientSpace.
+	WarnFreeSpace bool
+
+	// Recheck asks the server whether completed downloads have changed before downloading, see Recheck.
+	Recheck bool
+
+	// Only limits Run to these entities and the ones they refer to, see ExtractOnly.
+	// The rest of the index isn't refreshed, and only their downloads are attempted.
+	Only []accords_mirrorrer.Ref
+
+	// Profile is the name of the saved download policy to apply. Defaults to accords_mirrorrer.DefaultProfile,
+	// which allows everything until it's set.
+	Profile string
+
+	// SetProfile replaces the saved profile, before it's applied. An empty profile removes it.
+	SetProfile *accords_mirrorrer.Profile
+
+	// Order is the name of the strategy for ordering the downloads, see Orders. Defaults to DefaultOrder.
+	Order string
+
+	// RetryFailed retries failed downloads now, rather than backing off.
+	RetryFailed bool
+
+	// RetryGone retries downloads the server said don't exist.
+	RetryGone bool
+
+	// MaxRate limits the bytes received per second, across every request. 0 for no limit.
+	MaxRate int64
+
+	// MaxBytes stops the downloads once this many bytes have been received by this mirror,
+	// including the index. 0 for no limit.
+	MaxBytes int64
+
+	// StopAfter stops the downloads once this long has passed since the mirror was created. 0 for no limit.
+	StopAfter time.Duration
+
+	// Windows are the times of day downloads may run. Download waits for the next one to open,
+	// and stops when it closes. Empty for any time.
+	Windows []Window
+
+	// HTTP configures the client used for every request. It's ignored if HTTPClient is set.
+	HTTP HTTPOptions
+
+	// HTTPClient is used for every request, as-is.
+	HTTPClient *http.Client
+
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+
+	Hooks Hooks
+}
+
+// Mirror is a mirror of the library, backed by a state file and a download directory.
+type Mirror struct {
+	opts   Options
+	log    *slog.Logger
+	hc     *http.Client
+	client library.Client
+
+	meter   *meter
+	started time.Time
+	order   Order
+	filter  *accords_mirrorrer.Filter
+
+	// only is the set of URLs Download is limited to, if not nil.
+	only map[string]struct{}
+
+	// mu guards the state while downloads are running.
+	mu    sync.Mutex
+	state *accords_mirrorrer.State
+	locks []*accords_mirrorrer.Lock
+
+	// downloading is set while Download is running, and checkpoint when a Checkpoint is waiting for it.
+	downloading bool
+	checkpoint  bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a mirror. Call Open before anything else.
+func New(opts Options) (*Mirror, error) {
+	if opts.StateFile == "" {
+		opts.StateFile = "state.json"
+	}
+
+	if opts.Profile == "" {
+		opts.Profile = accords_mirrorrer.DefaultProfile
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	order, err := lookupOrder(opts.Order)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range opts.Only {
+		if err := checkEntity(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	hc := opts.HTTPClient
+	if hc == nil {
+		if hc, err = NewHTTPClient(opts.HTTP); err != nil {
+			return nil, err
+		}
+	}
+
+	mt := newMeter(opts.MaxRate, opts.MaxBytes)
+	hc = meteredClient(hc, mt)
+
+	return &Mirror{
+		opts:    opts,
+		log:     opts.Logger,
+		hc:      hc,
+		client:  library.NewClient(hc),
+		meter:   mt,
+		started: time.Now(),
+		order:   order,
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// Open locks the state file and the download directory, then loads the state.
+func (m *Mirror) Open(ctx context.Context) error {
+	lock, err := accords_mirrorrer.LockState(m.opts.StateFile, "archive")
+	if err != nil {
+		return err
+	}
+	m.locks = append(m.locks, lock)
+
+	if lock, err = accords_mirrorrer.LockDir(m.opts.Dir, "archive"); err != nil {
+		return errors.Join(err, m.Close())
+	}
+	m.locks = append(m.locks, lock)
+
+	state, err := accords_mirrorrer.LoadState(m.opts.StateFile)
+	if err != nil {
+		return errors.Join(err, m.Close())
+	}
+	m.log.InfoContext(ctx, "loaded state")
+
+	if m.filter, err = m.applyProfile(state); err != nil {
+		return errors.Join(err, m.Close())
+	}
+
+	if p := state.Profiles[m.opts.Profile]; !p.IsEmpty() {
+		m.log.With(
+			slog.String("profile", m.opts.Profile),
+			slog.Any("include", p.Include),
+			slog.Any("exclude", p.Exclude),
+			slog.Int64("max_file_size", p.MaxFileSize),
+			slog.Any("kinds", p.Kinds),
+			slog.Any("languages", p.Languages),
+		).InfoContext(ctx, "applying download profile")
+	}
+
+	if m.opts.Hooks.EntityFetched != nil {
+		state.OnEntityFetched(m.opts.Hooks.EntityFetched)
+	}
+
+	if m.opts.RefetchAfter > 0 {
+		state.RefetchOlderThan(m.opts.RefetchAfter)
+	}
+
+	m.state = state
+	return nil
+}
+
+// applyProfile updates the saved profile if requested, then compiles it.
+func (m *Mirror) applyProfile(state *accords_mirrorrer.State) (*accords_mirrorrer.Filter, error) {
+	name := m.opts.Profile
+
+	if p := m.opts.SetProfile; p != nil {
+		if _, err := p.Compile(); err != nil {
+			return nil, err
+		}
+
+		if p.IsEmpty() {
+			delete(state.Profiles, name)
+		} else {
+			if state.Profiles == nil {
+				state.Profiles = map[string]*accords_mirrorrer.Profile{}
+			}
+			state.Profiles[name] = p
+		}
+	}
+
+	p, exists := state.Profiles[name]
+	if !exists && name != accords_mirrorrer.DefaultProfile && m.opts.SetProfile == nil {
+		return nil, fmt.Errorf("no such profile: %v", name)
+	}
+
+	return p.Compile()
+}
+
+// Close releases the locks. It doesn't save the state.
+func (m *Mirror) Close() error {
+	var errs []error
+	for _, lock := range m.locks {
+		errs = append(errs, lock.Release())
+	}
+	m.locks = nil
+
+	return errors.Join(errs...)
+}
+
+// State returns the state. It must not be modified while downloads are running.
+func (m *Mirror) State() *accords_mirrorrer.State {
+	return m.state
+}
+
+// Save writes the state. It must not be called while the index is being refreshed or extracted,
+// use Checkpoint from other goroutines.
+func (m *Mirror) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return accords_mirrorrer.SaveState(m.state, m.opts.StateFile)
+}
+
+// Checkpoint saves the state if downloads are running. Otherwise the state may be being changed,
+// so it's saved once they start.
+func (m *Mirror) Checkpoint() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.downloading {
+		m.log.Info("saving the state once downloads start")
+		m.checkpoint = true
+		return nil
+	}
+
+	return accords_mirrorrer.SaveState(m.state, m.opts.StateFile)
+}
+
+// setDownloading marks the start or end of the downloads, saving the state if a Checkpoint is waiting.
+func (m *Mirror) setDownloading(downloading bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.downloading = downloading
+	if !downloading || !m.checkpoint {
+		return nil
+	}
+
+	m.checkpoint = false
+	return accords_mirrorrer.SaveState(m.state, m.opts.StateFile)
+}
+
+// Stop stops the mirror after the current step: no more kinds are refreshed, and no new downloads
+// are started. Downloads in progress are finished, cancel the context to abort them.
+func (m *Mirror) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *Mirror) stopped() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run refreshes the index, extracts the downloads and downloads them, as configured by the options.
+// With Options.Only, only the selected entities are refreshed and extracted, see ExtractOnly.
+// With Options.Recheck, the completed downloads are rechecked first, and the changes reported at the end.
+// The state is saved before returning, even if there was an error.
+func (m *Mirror) Run(ctx context.Context) error {
+	var err error
+	switch {
+	case len(m.opts.Only) > 0:
+		err = m.ExtractOnly(ctx)
+	case !m.opts.DontRefresh:
+		err = m.Refresh(ctx)
+	default:
+		m.log.InfoContext(ctx, "skipping index refresh by request")
+	}
+
+	if err != nil {
+		if err2 := m.Save(); err2 != nil {
+			err = errors.Join(err, err2)
+		}
+		return err
+	}
+
+	if len(m.opts.Only) == 0 {
+		if err := m.Extract(); err != nil {
+			if err2 := m.Save(); err2 != nil {
+				err = errors.Join(err, err2)
+			}
+			return err
+		}
+	}
+
+	m.log.InfoContext(ctx, "index update finished...")
+
+	var changes []Change
+	if m.opts.Recheck {
+		if changes, err = m.Recheck(ctx); err != nil {
+			if err2 := m.Save(); err2 != nil {
+				err = errors.Join(err, err2)
+			}
+			return err
+		}
+	}
+
+	if !m.opts.DontDownload {
+		err = m.Download(ctx)
+	} else {
+		m.log.InfoContext(ctx, "skipping download by request")
+	}
+
+	if m.opts.Recheck {
+		m.reportChanges(ctx, changes)
+	}
+
+	if err2 := m.Save(); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+
+	return err
+}
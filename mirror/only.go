@@ -0,0 +1,121 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// ParseEntity parses a "kind:slug" entity reference. The slug is omitted for singletons.
+func ParseEntity(s string) (accords_mirrorrer.Ref, error) {
+	kind, slug, _ := strings.Cut(s, ":")
+	ref := accords_mirrorrer.Ref{Kind: kind, Slug: slug}
+
+	if err := checkEntity(ref); err != nil {
+		return accords_mirrorrer.Ref{}, err
+	}
+
+	return ref, nil
+}
+
+func checkEntity(ref accords_mirrorrer.Ref) error {
+	k, exists := accords_mirrorrer.LookupKind(ref.Kind)
+	switch {
+	case !exists:
+		return fmt.Errorf("unknown kind: %v", ref.Kind)
+	case k.IsSingleton() && ref.Slug != "":
+		return fmt.Errorf("%v has a single entity, without a slug", ref.Kind)
+	case !k.IsSingleton() && ref.Slug == "":
+		return fmt.Errorf("missing %v slug", ref.Kind)
+	}
+
+	return nil
+}
+
+// selectEntities returns the entities of Options.Only and everything they refer to, fetching any
+// that are missing. With DontRefresh, missing entities are skipped instead.
+func (m *Mirror) selectEntities(ctx context.Context) ([]accords_mirrorrer.Ref, error) {
+	queue := make([]accords_mirrorrer.Ref, 0, len(m.opts.Only))
+	seen := map[accords_mirrorrer.Ref]struct{}{}
+
+	for _, ref := range m.opts.Only {
+		if _, exists := seen[ref]; !exists {
+			seen[ref] = struct{}{}
+			queue = append(queue, ref)
+		}
+	}
+
+	raw := m.state.RawEntities()
+
+	var selected []accords_mirrorrer.Ref
+	for current := 0; current < len(queue); current += 1 {
+		if m.stopped() {
+			return nil, ErrStopped
+		}
+
+		ref := queue[current]
+		k, _ := accords_mirrorrer.LookupKind(ref.Kind)
+		l := m.log.With(slog.String("kind", ref.Kind), slog.String("slug", ref.Slug))
+
+		if _, exists := raw[ref.Kind][ref.Slug]; !exists && m.opts.DontRefresh {
+			l.WarnContext(ctx, "skipping entity missing from the state")
+			continue
+		}
+
+		related, err := k.Related(ctx, m.state, m.client, ref.Slug)
+		if err != nil {
+			l.With(slog.Any("error", err)).ErrorContext(ctx, "error fetching entity")
+			return nil, err
+		}
+		selected = append(selected, ref)
+
+		for _, rel := range related {
+			if _, exists := seen[rel]; !exists {
+				seen[rel] = struct{}{}
+				queue = append(queue, rel)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+// ExtractOnly adds the downloads of the entities of Options.Only, following their subitems and contents,
+// and restricts Download to them. The references of other entities are left alone.
+func (m *Mirror) ExtractOnly(ctx context.Context) error {
+	selected, err := m.selectEntities(ctx)
+	if err != nil {
+		return err
+	}
+
+	dst := accords_mirrorrer.NewState()
+	for _, ref := range selected {
+		k, _ := accords_mirrorrer.LookupKind(ref.Kind)
+		if err := k.ExtractDownloads(m.state, ref.Slug, m.client, dst, m.log); err != nil {
+			return fmt.Errorf("error decoding %v entity %q: %w", ref.Kind, ref.Slug, err)
+		}
+	}
+
+	m.only = make(map[string]struct{}, len(dst.Downloads))
+
+	var errs []error
+	for u, d := range dst.Downloads {
+		if _, err := m.state.AddDownload(u, d.Refs...); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		m.only[u] = struct{}{}
+	}
+
+	m.log.With(
+		slog.Int("entities", len(selected)),
+		slog.Int("downloads", len(m.only)),
+	).InfoContext(ctx, "selected entities")
+
+	return errors.Join(errs...)
+}
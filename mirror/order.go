@@ -0,0 +1,161 @@
+package mirror
+
+import (
+	"fmt"
+	"sort"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// An Order sorts the pending downloads in place, into the order they're started.
+// They're passed in sorted by URL.
+type Order func(downloads []*accords_mirrorrer.Download)
+
+// DefaultOrder is used when Options.Order is empty.
+const DefaultOrder = "url"
+
+var orders = map[string]Order{
+	"url":         func([]*accords_mirrorrer.Download) {},
+	"smallest":    orderSmallest,
+	"role":        orderRole,
+	"entity":      orderEntity,
+	"round-robin": orderRoundRobin,
+}
+
+// RegisterOrder adds an ordering strategy, replacing any with the same name.
+func RegisterOrder(name string, order Order) {
+	orders[name] = order
+}
+
+// Orders returns the names of the ordering strategies.
+func Orders() []string {
+	names := make([]string, 0, len(orders))
+	for name := range orders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func lookupOrder(name string) (Order, error) {
+	if name == "" {
+		name = DefaultOrder
+	}
+
+	order, exists := orders[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown order %q, expected one of %v", name, Orders())
+	}
+
+	return order, nil
+}
+
+// orderSmallest starts with the smallest downloads, leaving those of unknown size until last.
+func orderSmallest(downloads []*accords_mirrorrer.Download) {
+	sort.SliceStable(downloads, func(i, j int) bool {
+		a, aKnown := downloads[i].KnownSize()
+		b, bKnown := downloads[j].KnownSize()
+
+		if aKnown != bKnown {
+			return aKnown
+		}
+
+		return a < b
+	})
+}
+
+// rolePriority is the order of orderRole. Roles not listed go last.
+var rolePriority = []string{
+	accords_mirrorrer.RoleThumbnail,
+	accords_mirrorrer.RoleOpenGraphImage,
+	accords_mirrorrer.RoleImage,
+	accords_mirrorrer.RoleScanPage,
+	accords_mirrorrer.RoleScan,
+	accords_mirrorrer.RoleSubtitle,
+	accords_mirrorrer.RoleTrack,
+	accords_mirrorrer.RoleAudio,
+	accords_mirrorrer.RoleOpenGraphAudio,
+	accords_mirrorrer.RoleVideo,
+	accords_mirrorrer.RoleOpenGraphVideo,
+	accords_mirrorrer.RoleScrape,
+}
+
+// bestRank returns the lowest rank of the references of a download, or n if none are ranked.
+func bestRank(d *accords_mirrorrer.Download, n int, rank func(ref accords_mirrorrer.Ref) (int, bool)) int {
+	best := n
+	for _, ref := range d.Refs {
+		if r, ok := rank(ref); ok && r < best {
+			best = r
+		}
+	}
+
+	return best
+}
+
+func sortByRank(downloads []*accords_mirrorrer.Download, n int, rank func(ref accords_mirrorrer.Ref) (int, bool)) {
+	ranks := make(map[*accords_mirrorrer.Download]int, len(downloads))
+	for _, d := range downloads {
+		ranks[d] = bestRank(d, n, rank)
+	}
+
+	sort.SliceStable(downloads, func(i, j int) bool {
+		return ranks[downloads[i]] < ranks[downloads[j]]
+	})
+}
+
+// orderRole starts with images and scans, leaving audio and video until last.
+func orderRole(downloads []*accords_mirrorrer.Download) {
+	ranks := make(map[string]int, len(rolePriority))
+	for i, role := range rolePriority {
+		ranks[role] = i
+	}
+
+	sortByRank(downloads, len(rolePriority), func(ref accords_mirrorrer.Ref) (int, bool) {
+		r, ok := ranks[ref.Role]
+		return r, ok
+	})
+}
+
+// orderEntity follows the order the kinds are registered in, leaving the scrape until last.
+func orderEntity(downloads []*accords_mirrorrer.Download) {
+	kinds := accords_mirrorrer.Kinds()
+
+	ranks := make(map[string]int, len(kinds))
+	for i, k := range kinds {
+		ranks[k.GetName()] = i
+	}
+
+	sortByRank(downloads, len(kinds), func(ref accords_mirrorrer.Ref) (int, bool) {
+		r, ok := ranks[ref.Kind]
+		return r, ok
+	})
+}
+
+// orderRoundRobin takes a download from each host in turn.
+func orderRoundRobin(downloads []*accords_mirrorrer.Download) {
+	var hosts []string
+	byHost := map[string][]*accords_mirrorrer.Download{}
+
+	for _, d := range downloads {
+		host := downloadHost(d)
+		if _, exists := byHost[host]; !exists {
+			hosts = append(hosts, host)
+		}
+
+		byHost[host] = append(byHost[host], d)
+	}
+
+	out := downloads[:0]
+	for len(hosts) > 0 {
+		remaining := hosts[:0]
+		for _, host := range hosts {
+			out = append(out, byHost[host][0])
+
+			if byHost[host] = byHost[host][1:]; len(byHost[host]) > 0 {
+				remaining = append(remaining, host)
+			}
+		}
+		hosts = remaining
+	}
+}
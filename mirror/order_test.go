@@ -0,0 +1,183 @@
+package mirror
+
+import (
+	"slices"
+	"testing"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// testDownload is a pending download for ordering. size is its known size, 0 if unknown.
+type testDownload struct {
+	url  string
+	size int64
+	refs []accords_mirrorrer.Ref
+}
+
+func buildDownloads(tds []testDownload) []*accords_mirrorrer.Download {
+	downloads := make([]*accords_mirrorrer.Download, 0, len(tds))
+	for _, td := range tds {
+		d := &accords_mirrorrer.Download{Refs: td.refs}
+		d.URL = td.url
+		d.ContentLength = td.size
+		downloads = append(downloads, d)
+	}
+
+	return downloads
+}
+
+func urls(downloads []*accords_mirrorrer.Download) []string {
+	out := make([]string, 0, len(downloads))
+	for _, d := range downloads {
+		out = append(out, d.URL)
+	}
+
+	return out
+}
+
+func TestOrders(t *testing.T) {
+	kinds := accords_mirrorrer.Kinds()
+	first, last := kinds[0].GetName(), kinds[len(kinds)-1].GetName()
+
+	role := func(role string) []accords_mirrorrer.Ref {
+		return []accords_mirrorrer.Ref{{Kind: first, Role: role}}
+	}
+
+	kind := func(kind string) []accords_mirrorrer.Ref {
+		return []accords_mirrorrer.Ref{{Kind: kind, Role: accords_mirrorrer.RoleImage}}
+	}
+
+	tests := []struct {
+		name      string
+		order     string
+		downloads []testDownload
+		want      []string
+	}{
+		{
+			name:  "url leaves them alone",
+			order: "url",
+			downloads: []testDownload{
+				{url: "https://a.example.com/2", size: 1},
+				{url: "https://a.example.com/1", size: 2},
+			},
+			want: []string{"https://a.example.com/2", "https://a.example.com/1"},
+		},
+		{
+			name:  "smallest first, unknown sizes last",
+			order: "smallest",
+			downloads: []testDownload{
+				{url: "https://a.example.com/unknown-1"},
+				{url: "https://a.example.com/big", size: 300},
+				{url: "https://a.example.com/small", size: 1},
+				{url: "https://a.example.com/unknown-2"},
+				{url: "https://a.example.com/medium", size: 20},
+			},
+			want: []string{
+				"https://a.example.com/small",
+				"https://a.example.com/medium",
+				"https://a.example.com/big",
+				"https://a.example.com/unknown-1",
+				"https://a.example.com/unknown-2",
+			},
+		},
+		{
+			name:  "smallest keeps ties in order",
+			order: "smallest",
+			downloads: []testDownload{
+				{url: "https://a.example.com/1", size: 5},
+				{url: "https://a.example.com/2", size: 5},
+				{url: "https://a.example.com/3", size: 1},
+			},
+			want: []string{"https://a.example.com/3", "https://a.example.com/1", "https://a.example.com/2"},
+		},
+		{
+			name:  "role, images before scans before audio and video",
+			order: "role",
+			downloads: []testDownload{
+				{url: "https://a.example.com/video", refs: role(accords_mirrorrer.RoleVideo)},
+				{url: "https://a.example.com/unranked", refs: role("other")},
+				{url: "https://a.example.com/none"},
+				{url: "https://a.example.com/audio", refs: role(accords_mirrorrer.RoleAudio)},
+				{url: "https://a.example.com/scan", refs: role(accords_mirrorrer.RoleScan)},
+				{url: "https://a.example.com/thumbnail", refs: role(accords_mirrorrer.RoleThumbnail)},
+			},
+			want: []string{
+				"https://a.example.com/thumbnail",
+				"https://a.example.com/scan",
+				"https://a.example.com/audio",
+				"https://a.example.com/video",
+				"https://a.example.com/unranked",
+				"https://a.example.com/none",
+			},
+		},
+		{
+			name:  "role uses the best of several references",
+			order: "role",
+			downloads: []testDownload{
+				{url: "https://a.example.com/audio", refs: role(accords_mirrorrer.RoleAudio)},
+				{url: "https://a.example.com/both", refs: append(role(accords_mirrorrer.RoleVideo), role(accords_mirrorrer.RoleImage)...)},
+			},
+			want: []string{"https://a.example.com/both", "https://a.example.com/audio"},
+		},
+		{
+			name:  "entity follows the registered kinds",
+			order: "entity",
+			downloads: []testDownload{
+				{url: "https://a.example.com/scrape", refs: kind(accords_mirrorrer.KindScrape)},
+				{url: "https://a.example.com/last", refs: kind(last)},
+				{url: "https://a.example.com/none"},
+				{url: "https://a.example.com/first", refs: kind(first)},
+			},
+			want: []string{
+				"https://a.example.com/first",
+				"https://a.example.com/last",
+				"https://a.example.com/scrape",
+				"https://a.example.com/none",
+			},
+		},
+		{
+			name:  "round-robin takes a download from each host in turn",
+			order: "round-robin",
+			downloads: []testDownload{
+				{url: "https://a.example.com/1"},
+				{url: "https://a.example.com/2"},
+				{url: "https://a.example.com/3"},
+				{url: "https://b.example.com/1"},
+				{url: "https://c.example.com/1"},
+				{url: "https://c.example.com/2"},
+			},
+			want: []string{
+				"https://a.example.com/1",
+				"https://b.example.com/1",
+				"https://c.example.com/1",
+				"https://a.example.com/2",
+				"https://c.example.com/2",
+				"https://a.example.com/3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		order, err := lookupOrder(tt.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		downloads := buildDownloads(tt.downloads)
+		order(downloads)
+
+		if got := urls(downloads); !slices.Equal(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookupOrder(t *testing.T) {
+	if _, err := lookupOrder(""); err != nil {
+		t.Errorf("the default order: %v", err)
+	}
+
+	if _, err := lookupOrder("largest"); err == nil {
+		t.Error("expected an error for an unknown order")
+	}
+}
@@ -0,0 +1,147 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"git.vs49688.net/zane/goutils/parallel"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// ErrInsufficientSpace is returned by Download when the pending downloads won't fit on the output filesystem.
+var ErrInsufficientSpace = errors.New("not enough free space")
+
+// headSize asks the server for the size of a download, without downloading it.
+func (m *Mirror) headSize(ctx context.Context, u string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := m.hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status: %v", resp.Status)
+	}
+
+	return resp.ContentLength, nil
+}
+
+// remainingBytes returns how much more of a download there is to write, and whether it's known.
+// Partial files count towards it.
+func (m *Mirror) remainingBytes(d *accords_mirrorrer.Download) (int64, bool) {
+	size, known := d.KnownSize()
+	if !known {
+		return 0, false
+	}
+
+	if fi, err := os.Stat(filepath.Join(m.opts.Dir, filepath.FromSlash(d.OutPath))); err == nil && fi.Size() < size {
+		return size - fi.Size(), true
+	}
+
+	return size, true
+}
+
+// sizeDownloads sizes the pending downloads of unknown length with HEAD requests, returning how many were sized.
+func (m *Mirror) sizeDownloads(ctx context.Context, pending []*accords_mirrorrer.Download) (int, error) {
+	var unknown []*accords_mirrorrer.Download
+	for _, d := range pending {
+		if _, known := d.KnownSize(); !known {
+			unknown = append(unknown, d)
+		}
+	}
+
+	if len(unknown) == 0 {
+		return 0, nil
+	}
+
+	m.log.With(slog.Int("downloads", len(unknown))).InfoContext(ctx, "sizing downloads")
+
+	errs := parallel.Parallel(ctx, m.opts.Parallelism, unknown, func(ctx context.Context, d *accords_mirrorrer.Download) error {
+		if m.stopped() {
+			return ErrStopped
+		}
+
+		size, err := m.headSize(ctx, d.URL)
+		if err != nil || size <= 0 {
+			return err
+		}
+
+		m.mu.Lock()
+		d.ContentLength = size
+		m.mu.Unlock()
+
+		return nil
+	})
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	} else if m.stopped() {
+		return 0, ErrStopped
+	}
+
+	numSized := len(unknown)
+	for i, err := range errs {
+		if err != nil {
+			numSized -= 1
+			m.log.With(slog.String("url", unknown[i].URL), slog.Any("error", err)).DebugContext(ctx, "error sizing download")
+		}
+	}
+
+	return numSized, nil
+}
+
+// checkFreeSpace checks the pending downloads will fit in the free space of the output directory.
+func (m *Mirror) checkFreeSpace(ctx context.Context, pending []*accords_mirrorrer.Download) error {
+	var (
+		needed     int64
+		numUnknown int
+	)
+
+	for _, d := range pending {
+		if remaining, known := m.remainingBytes(d); known {
+			needed += remaining
+		} else {
+			numUnknown += 1
+		}
+	}
+
+	dir := m.opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("error checking free space: %w", err)
+	}
+
+	l := m.log.With(
+		slog.Int64("needed_bytes", needed),
+		slog.Uint64("free_bytes", free),
+		slog.Int("unknown_sizes", numUnknown),
+	)
+
+	if needed <= 0 || uint64(needed) <= free {
+		l.InfoContext(ctx, "downloads will fit")
+		return nil
+	}
+
+	if m.opts.WarnFreeSpace {
+		l.WarnContext(ctx, "downloads won't fit, continuing anyway")
+		return nil
+	}
+
+	l.ErrorContext(ctx, "downloads won't fit")
+	return fmt.Errorf("%w: need %v bytes, have %v", ErrInsufficientSpace, needed, free)
+}
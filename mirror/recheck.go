@@ -0,0 +1,293 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"git.vs49688.net/zane/goutils/parallel"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// Change is a completed download that changed upstream, found by Recheck.
+type Change struct {
+	URL string
+
+	// OldPath is where the previous version was moved to, relative to the download directory.
+	OldPath         string
+	OldSize         int64
+	OldLastModified string
+
+	NewSize         int64
+	NewLastModified string
+}
+
+// validators records the validators of the successful GET responses of a download.
+type validators struct {
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+}
+
+func (v *validators) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := v.next.RoundTrip(req)
+	if err == nil && req.Method == http.MethodGet && resp.StatusCode/100 == 2 {
+		v.mu.Lock()
+		v.etag = resp.Header.Get("ETag")
+		v.lastModified = resp.Header.Get("Last-Modified")
+		v.mu.Unlock()
+	}
+
+	return resp, err
+}
+
+// recordValidators returns a copy of hc that records the validators of its responses.
+func recordValidators(hc *http.Client) (*http.Client, *validators) {
+	v := &validators{next: hc.Transport}
+	if v.next == nil {
+		v.next = http.DefaultTransport
+	}
+
+	c := *hc
+	c.Transport = v
+	return &c, v
+}
+
+// setModTime sets the modification time of a file from a Last-Modified header.
+func setModTime(name, lastModified string) error {
+	t, err := http.ParseTime(lastModified)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(name, time.Time{}, t)
+}
+
+// versionStamp is the layout of the timestamp in the paths of previous versions.
+const versionStamp = "20060102T150405Z"
+
+var versionPathPattern = regexp.MustCompile(`^(.+)\.[0-9]{8}T[0-9]{6}Z(?:-[0-9]+)?(\.[^./]*)?$`)
+
+// CurrentPath returns the path of the download a previous version was moved aside from,
+// if outPath looks like one. It's the inverse of the naming used by Recheck.
+func CurrentPath(outPath string) (string, bool) {
+	m := versionPathPattern.FindStringSubmatch(outPath)
+	if m == nil {
+		return "", false
+	}
+
+	return m[1] + m[2], true
+}
+
+// versionPath returns where to keep a previous version of a file, stamped with when it was modified.
+func (m *Mirror) versionPath(d *accords_mirrorrer.Download) string {
+	stamp, err := http.ParseTime(d.LastModified)
+	if err != nil {
+		if fi, err := os.Stat(filepath.Join(m.opts.Dir, filepath.FromSlash(d.OutPath))); err == nil {
+			stamp = fi.ModTime()
+		} else {
+			stamp = time.Now()
+		}
+	}
+
+	ext := path.Ext(d.OutPath)
+	base := strings.TrimSuffix(d.OutPath, ext) + "." + stamp.UTC().Format(versionStamp)
+
+	out := base + ext
+	for i := 1; ; i += 1 {
+		if _, err := os.Stat(filepath.Join(m.opts.Dir, filepath.FromSlash(out))); errors.Is(err, os.ErrNotExist) {
+			return out
+		}
+
+		out = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// recheckOne asks whether a completed download has changed, using its validators if it has them,
+// and its size otherwise. The validators of a download without them are recorded.
+func (m *Mirror) recheckOne(ctx context.Context, d *accords_mirrorrer.Download) (bool, int64, string, error) {
+	m.mu.Lock()
+	di := *d
+	m.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, di.URL, nil)
+	if err != nil {
+		return false, 0, "", err
+	}
+
+	if di.ETag != "" {
+		req.Header.Set("If-None-Match", di.ETag)
+	}
+
+	if di.LastModified != "" {
+		req.Header.Set("If-Modified-Since", di.LastModified)
+	}
+
+	resp, err := m.hc.Do(req)
+	if err != nil {
+		return false, 0, "", err
+	}
+	_ = resp.Body.Close()
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return false, 0, "", nil
+	case resp.StatusCode != http.StatusOK:
+		return false, 0, "", fmt.Errorf("unexpected status: %v", resp.Status)
+	}
+
+	// Servers may ignore the conditions, so compare the validators ourselves.
+	var changed bool
+	switch {
+	case di.ETag != "" && etag != "":
+		changed = etag != di.ETag
+	case di.LastModified != "" && lastModified != "":
+		changed = lastModified != di.LastModified
+	default:
+		changed = resp.ContentLength >= 0 && resp.ContentLength != di.Size
+	}
+
+	// Keep the validators we have if the server didn't send them this time.
+	if !changed {
+		m.mu.Lock()
+		if etag != "" {
+			d.ETag = etag
+		}
+
+		if lastModified != "" {
+			d.LastModified = lastModified
+		}
+		m.mu.Unlock()
+	}
+
+	return changed, resp.ContentLength, lastModified, nil
+}
+
+// Recheck asks the server whether the completed downloads have changed. Changed files are moved aside,
+// kept as previous versions of the download, and queued to be downloaded again.
+func (m *Mirror) Recheck(ctx context.Context) ([]Change, error) {
+	var completed []*accords_mirrorrer.Download
+	for u, d := range m.state.Downloads {
+		if _, selected := m.only[u]; m.only != nil && !selected {
+			continue
+		}
+
+		if d.Completed && m.filter.Wants(d) {
+			completed = append(completed, d)
+		}
+	}
+
+	m.log.With(slog.Int("downloads", len(completed))).InfoContext(ctx, "rechecking completed downloads")
+
+	var (
+		mu      sync.Mutex
+		changes []Change
+	)
+
+	errs := parallel.Parallel(ctx, m.opts.Parallelism, completed, func(ctx context.Context, d *accords_mirrorrer.Download) error {
+		if m.stopped() {
+			return ErrStopped
+		}
+
+		changed, size, lastModified, err := m.recheckOne(ctx, d)
+		if err != nil || !changed {
+			return err
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		c := Change{
+			URL:             d.URL,
+			OldPath:         m.versionPath(d),
+			OldSize:         d.Size,
+			OldLastModified: d.LastModified,
+			NewSize:         size,
+			NewLastModified: lastModified,
+		}
+
+		src := filepath.Join(m.opts.Dir, filepath.FromSlash(d.OutPath))
+		dst := filepath.Join(m.opts.Dir, filepath.FromSlash(c.OldPath))
+		if err := os.Rename(src, dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		d.KeepVersion(c.OldPath, time.Now().UTC())
+		if size > 0 {
+			d.ContentLength = size
+		}
+
+		mu.Lock()
+		changes = append(changes, c)
+		mu.Unlock()
+
+		return nil
+	})
+
+	if err := ctx.Err(); err != nil {
+		return changes, err
+	} else if m.stopped() {
+		return changes, ErrStopped
+	}
+
+	var numErrors int
+	for i, err := range errs {
+		if err != nil {
+			numErrors += 1
+			m.log.With(slog.String("url", completed[i].URL), slog.Any("error", err)).WarnContext(ctx, "error rechecking download")
+		}
+	}
+
+	m.log.With(
+		slog.Int("checked", len(completed)),
+		slog.Int("changed", len(changes)),
+		slog.Int("errors", numErrors),
+	).InfoContext(ctx, "recheck finished")
+
+	return changes, nil
+}
+
+// reportChanges logs the changes found by Recheck, and whether they've been downloaded again.
+func (m *Mirror) reportChanges(ctx context.Context, changes []Change) {
+	var numDownloaded int
+	for _, c := range changes {
+		d := m.state.Downloads[c.URL]
+		if d.Completed {
+			numDownloaded += 1
+		}
+
+		newSize, newLastModified := c.NewSize, c.NewLastModified
+		if d.Completed {
+			newSize, newLastModified = d.Size, d.LastModified
+		}
+
+		m.log.With(
+			slog.String("url", c.URL),
+			slog.String("old_path", c.OldPath),
+			slog.Int64("old_size", c.OldSize),
+			slog.Int64("new_size", newSize),
+			slog.String("old_last_modified", c.OldLastModified),
+			slog.String("new_last_modified", newLastModified),
+			slog.Bool("downloaded", d.Completed),
+		).InfoContext(ctx, "changed upstream")
+	}
+
+	m.log.With(
+		slog.Int("changed", len(changes)),
+		slog.Int("downloaded", numDownloaded),
+	).InfoContext(ctx, "change report")
+}
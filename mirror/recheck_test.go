@@ -0,0 +1,116 @@
+package mirror
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+func TestRecheckOne(t *testing.T) {
+	const (
+		oldTime = "Fri, 02 Jan 2026 03:04:05 GMT"
+		newTime = "Sat, 03 Jan 2026 03:04:05 GMT"
+	)
+
+	tests := []struct {
+		name string
+
+		// The stored validators and size.
+		etag, lastModified string
+		size               int64
+
+		// The response.
+		status           int
+		respETag         string
+		respLastModified string
+		respSize         int64
+
+		changed bool
+	}{
+		{name: "not modified", etag: `"a"`, size: 10, status: http.StatusNotModified},
+		{name: "same etag", etag: `"a"`, size: 10, status: http.StatusOK, respETag: `"a"`, respSize: 20},
+		{name: "new etag", etag: `"a"`, size: 10, status: http.StatusOK, respETag: `"b"`, respSize: 10, changed: true},
+		{name: "same last modified", lastModified: oldTime, size: 10, status: http.StatusOK, respLastModified: oldTime, respSize: 20},
+		{name: "new last modified", lastModified: oldTime, size: 10, status: http.StatusOK, respLastModified: newTime, respSize: 10, changed: true},
+		{name: "no validators, same size", etag: `"a"`, lastModified: oldTime, size: 10, status: http.StatusOK, respSize: 10},
+		{name: "no validators, new size", size: 10, status: http.StatusOK, respSize: 11, changed: true},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.respETag != "" {
+				w.Header().Set("ETag", tt.respETag)
+			}
+
+			if tt.respLastModified != "" {
+				w.Header().Set("Last-Modified", tt.respLastModified)
+			}
+
+			w.Header().Set("Content-Length", strconv.FormatInt(tt.respSize, 10))
+			w.WriteHeader(tt.status)
+		}))
+
+		d := &accords_mirrorrer.Download{ETag: tt.etag, LastModified: tt.lastModified}
+		d.URL = srv.URL + "/a.pdf"
+		d.Size = tt.size
+		d.Completed = true
+
+		m := &Mirror{hc: srv.Client()}
+		changed, _, _, err := m.recheckOne(context.Background(), d)
+		srv.Close()
+
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if changed != tt.changed {
+			t.Errorf("%v: changed = %v, want %v", tt.name, changed, tt.changed)
+		}
+
+		if changed {
+			continue
+		}
+
+		// Unchanged downloads keep their validators, unless the server sent new ones.
+		wantETag, wantLastModified := tt.etag, tt.lastModified
+		if tt.respETag != "" {
+			wantETag = tt.respETag
+		}
+
+		if tt.respLastModified != "" {
+			wantLastModified = tt.respLastModified
+		}
+
+		if d.ETag != wantETag || d.LastModified != wantLastModified {
+			t.Errorf("%v: validators %q, %q, want %q, %q", tt.name, d.ETag, d.LastModified, wantETag, wantLastModified)
+		}
+	}
+}
+
+func TestCurrentPath(t *testing.T) {
+	tests := []struct {
+		outPath string
+		current string
+		ok      bool
+	}{
+		{outPath: "a.example.com/a.20260102T030405Z.pdf", current: "a.example.com/a.pdf", ok: true},
+		{outPath: "a.example.com/a.20260102T030405Z-2.pdf", current: "a.example.com/a.pdf", ok: true},
+		{outPath: "a.example.com/a.20260102T030405Z", current: "a.example.com/a", ok: true},
+		{outPath: "a.example.com/a.tar.20260102T030405Z.gz", current: "a.example.com/a.tar.gz", ok: true},
+		{outPath: "a.example.com/a.pdf"},
+		{outPath: "a.example.com/a.20260102.pdf"},
+		{outPath: "a.example.com/a.20260102T030405Z.pdf/b"},
+	}
+
+	for _, tt := range tests {
+		current, ok := CurrentPath(tt.outPath)
+		if ok != tt.ok || current != tt.current {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.outPath, current, ok, tt.current, tt.ok)
+		}
+	}
+}
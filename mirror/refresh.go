@@ -0,0 +1,55 @@
+package mirror
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"sort"
+
+	"git.vs49688.net/zane/goutils/download"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// ScrapeRoot is where the directory listing of the asset storage starts.
+var ScrapeRoot = &url.URL{Scheme: "https", Host: "resha.re", Path: "/accords/"}
+
+// Refresh fetches the entities of every registered kind, then scrapes the asset storage.
+func (m *Mirror) Refresh(ctx context.Context) error {
+	for _, k := range accords_mirrorrer.Kinds() {
+		if m.stopped() {
+			return ErrStopped
+		}
+
+		if err := k.Refresh(ctx, m.state, m.client, m.log); err != nil {
+			return err
+		}
+	}
+
+	if m.stopped() {
+		return ErrStopped
+	}
+
+	// Now do a brute-force scrape of the actual storage, just in case. Thankfully, they've got directory listing enabled.
+	resURLs, err := download.ScrapeIndex(ctx, ScrapeRoot, m.hc, m.log)
+	if err != nil {
+		m.log.With(slog.Any("error", err)).ErrorContext(ctx, "error scraping urls")
+		return err
+	}
+
+	m.state.Scraped = make([]string, 0, len(resURLs))
+	scrapeRef := accords_mirrorrer.Ref{Kind: accords_mirrorrer.KindScrape, Role: accords_mirrorrer.RoleScrape}
+
+	for _, u := range resURLs {
+		if _, err := m.state.AddDownload(u.String(), scrapeRef); err != nil {
+			m.log.With(slog.Any("error", err), slog.String("url", u.String())).ErrorContext(ctx, "error scraping urls")
+			continue
+		}
+
+		m.state.Scraped = append(m.state.Scraped, u.String())
+	}
+
+	sort.Strings(m.state.Scraped)
+
+	return nil
+}
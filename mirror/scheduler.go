@@ -0,0 +1,128 @@
+package mirror
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+// DefaultHost is the HostParallelism key applying to hosts without their own limit.
+const DefaultHost = "*"
+
+type hostQueue struct {
+	host   string
+	limit  int
+	active int
+
+	// queue holds indices into scheduler.downloads, in order.
+	queue []int
+}
+
+// scheduler hands out downloads in order, skipping those whose host is at its limit.
+type scheduler struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	downloads []*accords_mirrorrer.Download
+	hosts     []*hostQueue
+	byHost    map[string]*hostQueue
+}
+
+func downloadHost(d *accords_mirrorrer.Download) string {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
+
+// newScheduler creates a scheduler of the downloads, limiting the concurrent downloads of each host.
+// A limit <1 means no limit.
+func newScheduler(downloads []*accords_mirrorrer.Download, limits map[string]int) *scheduler {
+	s := &scheduler{
+		downloads: downloads,
+		byHost:    map[string]*hostQueue{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	for i, d := range downloads {
+		host := downloadHost(d)
+
+		hq, exists := s.byHost[host]
+		if !exists {
+			limit, exists := limits[host]
+			if !exists {
+				limit = limits[DefaultHost]
+			}
+
+			hq = &hostQueue{host: host, limit: limit}
+			s.byHost[host] = hq
+			s.hosts = append(s.hosts, hq)
+		}
+
+		hq.queue = append(hq.queue, i)
+	}
+
+	return s
+}
+
+// next returns the earliest download whose host isn't at its limit, waiting for one to finish if need be.
+// It returns nil once there are none left, or the context is done.
+func (s *scheduler) next(ctx context.Context) *accords_mirrorrer.Download {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var best *hostQueue
+		remaining := false
+		for _, hq := range s.hosts {
+			if len(hq.queue) == 0 {
+				continue
+			}
+			remaining = true
+
+			if hq.limit > 0 && hq.active >= hq.limit {
+				continue
+			}
+
+			if best == nil || hq.queue[0] < best.queue[0] {
+				best = hq
+			}
+		}
+
+		if !remaining {
+			return nil
+		}
+
+		if best != nil {
+			i := best.queue[0]
+			best.queue = best.queue[1:]
+			best.active += 1
+			return s.downloads[i]
+		}
+
+		s.cond.Wait()
+	}
+}
+
+// done marks a download returned by next as finished.
+func (s *scheduler) done(d *accords_mirrorrer.Download) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byHost[downloadHost(d)].active -= 1
+	s.cond.Broadcast()
+}
@@ -0,0 +1,151 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	accords_mirrorrer "git.vs49688.net/zane/accords-mirrorrer"
+)
+
+func testDownloads(urls ...string) []*accords_mirrorrer.Download {
+	downloads := make([]*accords_mirrorrer.Download, 0, len(urls))
+	for _, u := range urls {
+		d := &accords_mirrorrer.Download{}
+		d.URL = u
+		downloads = append(downloads, d)
+	}
+
+	return downloads
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	const parallelism = 6
+
+	limits := map[string]int{
+		"a.example.com": 2,
+		"b.example.com": 1,
+		DefaultHost:     3,
+	}
+
+	var urls []string
+	for i := 0; i < 20; i += 1 {
+		for _, host := range []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"} {
+			urls = append(urls, fmt.Sprintf("https://%s/%d", host, i))
+		}
+	}
+
+	downloads := testDownloads(urls...)
+	sched := newScheduler(downloads, limits)
+
+	var (
+		mu                sync.Mutex
+		active, maxActive int
+		hostActive        = map[string]int{}
+		hostMax           = map[string]int{}
+		seen              = map[*accords_mirrorrer.Download]int{}
+	)
+
+	wg := sync.WaitGroup{}
+	for range parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for d := sched.next(context.Background()); d != nil; d = sched.next(context.Background()) {
+				host := downloadHost(d)
+
+				mu.Lock()
+				seen[d] += 1
+				active += 1
+				hostActive[host] += 1
+				maxActive = max(maxActive, active)
+				hostMax[host] = max(hostMax[host], hostActive[host])
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				active -= 1
+				hostActive[host] -= 1
+				mu.Unlock()
+
+				sched.done(d)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if maxActive > parallelism {
+		t.Errorf("%v downloads in flight, want at most %v", maxActive, parallelism)
+	}
+
+	for host, n := range hostMax {
+		limit, exists := limits[host]
+		if !exists {
+			limit = limits[DefaultHost]
+		}
+
+		if n > limit {
+			t.Errorf("%v: %v downloads in flight, want at most %v", host, n, limit)
+		}
+	}
+
+	for _, d := range downloads {
+		if seen[d] != 1 {
+			t.Errorf("%v was handed out %v times", d.URL, seen[d])
+		}
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	downloads := testDownloads(
+		"https://a.example.com/1",
+		"https://b.example.com/1",
+		"https://a.example.com/2",
+		"https://c.example.com/1",
+		"https://a.example.com/3",
+	)
+
+	sched := newScheduler(downloads, map[string]int{"a.example.com": 1})
+
+	// a.example.com/2 waits for a.example.com/1, and the others go ahead of it.
+	want := []string{
+		"https://a.example.com/1",
+		"https://b.example.com/1",
+		"https://c.example.com/1",
+	}
+
+	for _, u := range want {
+		if d := sched.next(context.Background()); d == nil || d.URL != u {
+			t.Fatalf("next() = %v, want %v", d, u)
+		}
+	}
+
+	// Nothing is left until a.example.com/1 is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if d := sched.next(ctx); d != nil {
+		t.Fatalf("next() = %v while the host is at its limit", d.URL)
+	}
+
+	sched.done(downloads[0])
+
+	if d := sched.next(context.Background()); d == nil || d.URL != "https://a.example.com/2" {
+		t.Fatalf("next() = %v, want https://a.example.com/2", d)
+	}
+
+	sched.done(downloads[2])
+
+	if d := sched.next(context.Background()); d == nil || d.URL != "https://a.example.com/3" {
+		t.Fatalf("next() = %v, want https://a.example.com/3", d)
+	}
+
+	if d := sched.next(context.Background()); d != nil {
+		t.Fatalf("next() = %v, want nil once everything's handed out", d.URL)
+	}
+}
@@ -0,0 +1,223 @@
+package accords_mirrorrer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultProfile is the name of the profile used if none is given.
+const DefaultProfile = "default"
+
+// AssetKinds group the roles of downloads, for filtering. A role may also be used directly.
+var AssetKinds = map[string][]string{
+	"scan":     {RoleScan, RoleScanPage},
+	"image":    {RoleImage, RoleThumbnail, RoleOpenGraphImage},
+	"track":    {RoleTrack},
+	"audio":    {RoleAudio, RoleOpenGraphAudio},
+	"video":    {RoleVideo, RoleOpenGraphVideo},
+	"subtitle": {RoleSubtitle},
+	"scrape":   {RoleScrape},
+}
+
+// Profile is a named download policy, saved in the state so later runs apply it too.
+type Profile struct {
+	// Include and Exclude match the URL or out path of a download. A pattern is a glob, where
+	// * doesn't match a "/" and ** does, or a regular expression if it starts with "re:".
+	// If there are any includes, a download must match one of them.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	// MaxFileSize skips downloads known to be larger. 0 for no limit.
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+
+	// Kinds limits the downloads to those with one of these roles, or AssetKinds.
+	Kinds []string `json:"kinds,omitempty"`
+
+	// Languages limits the per-language downloads, such as translated audio and scan sets, to these
+	// language codes. Downloads that aren't specific to a language are unaffected.
+	Languages []string `json:"languages,omitempty"`
+}
+
+// IsEmpty returns whether the profile allows everything.
+func (p *Profile) IsEmpty() bool {
+	return p == nil || (len(p.Include) == 0 && len(p.Exclude) == 0 && p.MaxFileSize == 0 && len(p.Kinds) == 0 && len(p.Languages) == 0)
+}
+
+// globToRegexp translates a glob into an anchored regular expression.
+func globToRegexp(glob string) string {
+	sb := strings.Builder{}
+	sb.WriteString("^")
+
+	for i := 0; i < len(glob); i += 1 {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i += 1
+		case glob[i] == '*':
+			sb.WriteString("[^/]*")
+		case glob[i] == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	sb.WriteString("$")
+	return sb.String()
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		expr, isRegexp := strings.CutPrefix(p, "re:")
+		if !isRegexp {
+			expr = globToRegexp(p)
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+
+		out = append(out, re)
+	}
+
+	return out, nil
+}
+
+// Reasons a Filter skips a download, as returned by Filter.Skips.
+const (
+	SkippedInclude  = "include"
+	SkippedExclude  = "exclude"
+	SkippedSize     = "size"
+	SkippedKind     = "kind"
+	SkippedLanguage = "language"
+)
+
+// Filter is a compiled Profile.
+type Filter struct {
+	include   []*regexp.Regexp
+	exclude   []*regexp.Regexp
+	maxSize   int64
+	roles     map[string]struct{}
+	languages map[string]struct{}
+}
+
+// Compile checks the profile, returning a Filter. A nil profile allows everything.
+func (p *Profile) Compile() (*Filter, error) {
+	if p == nil {
+		return &Filter{}, nil
+	}
+
+	f := &Filter{maxSize: p.MaxFileSize}
+
+	var err error
+	if f.include, err = compilePatterns(p.Include); err != nil {
+		return nil, err
+	}
+
+	if f.exclude, err = compilePatterns(p.Exclude); err != nil {
+		return nil, err
+	}
+
+	if len(p.Kinds) > 0 {
+		f.roles = map[string]struct{}{}
+	}
+
+	for _, kind := range p.Kinds {
+		roles, exists := AssetKinds[kind]
+		if !exists {
+			roles = []string{kind}
+		}
+
+		for _, role := range roles {
+			f.roles[role] = struct{}{}
+		}
+	}
+
+	if len(p.Languages) > 0 {
+		f.languages = map[string]struct{}{}
+	}
+
+	for _, lang := range p.Languages {
+		f.languages[strings.ToLower(lang)] = struct{}{}
+	}
+
+	return f, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, d *Download) bool {
+	for _, re := range patterns {
+		if re.MatchString(d.URL) || re.MatchString(d.OutPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wants returns whether the download passes the filter. Downloads of unknown size pass
+// any size limit.
+func (f *Filter) Wants(d *Download) bool {
+	return f.Skips(d) == ""
+}
+
+// Skips returns why the filter skips the download, or "" if it doesn't.
+func (f *Filter) Skips(d *Download) string {
+	if len(f.include) > 0 && !matchAny(f.include, d) {
+		return SkippedInclude
+	}
+
+	if matchAny(f.exclude, d) {
+		return SkippedExclude
+	}
+
+	if size, known := d.KnownSize(); f.maxSize > 0 && known && size > f.maxSize {
+		return SkippedSize
+	}
+
+	if f.roles != nil && !anyRef(d, f.wantsRole) {
+		return SkippedKind
+	}
+
+	if f.languages != nil && !f.wantsLanguage(d) {
+		return SkippedLanguage
+	}
+
+	return ""
+}
+
+func anyRef(d *Download, wants func(ref Ref) bool) bool {
+	for _, ref := range d.Refs {
+		if wants(ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *Filter) wantsRole(ref Ref) bool {
+	_, exists := f.roles[ref.Role]
+	return exists
+}
+
+// wantsLanguage accepts downloads in one of the languages, or that aren't specific to one. References
+// without a language, such as the scrape's, don't count if others give the download a language.
+func (f *Filter) wantsLanguage(d *Download) bool {
+	var specific bool
+	for _, ref := range d.Refs {
+		if ref.Lang == "" {
+			continue
+		}
+
+		if _, exists := f.languages[strings.ToLower(ref.Lang)]; exists {
+			return true
+		}
+
+		specific = true
+	}
+
+	return !specific
+}
@@ -0,0 +1,205 @@
+package accords_mirrorrer
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		match []string
+		miss  []string
+	}{
+		{
+			glob:  "*.pdf",
+			match: []string{"a.pdf", ".pdf"},
+			miss:  []string{"dir/a.pdf", "a.pdf.zip", "a.PDF"},
+		},
+		{
+			glob:  "**.pdf",
+			match: []string{"a.pdf", "dir/a.pdf", "a/b/c.pdf"},
+			miss:  []string{"a.pdfx"},
+		},
+		{
+			glob:  "**/videos/**",
+			match: []string{"host/videos/a.mp4", "a/b/videos/c/d.mp4"},
+			miss:  []string{"videos/a.mp4", "host/videos", "host/myvideos/a.mp4"},
+		},
+		{
+			glob:  "host/*/file",
+			match: []string{"host/a/file", "host//file"},
+			miss:  []string{"host/a/b/file", "host/file"},
+		},
+		{
+			glob:  "file?.txt",
+			match: []string{"file1.txt", "filea.txt"},
+			miss:  []string{"file.txt", "file12.txt", "file/.txt"},
+		},
+		{
+			glob:  "a+b(c)[d].txt",
+			match: []string{"a+b(c)[d].txt"},
+			miss:  []string{"aab(c)d.txt", "a+b(c)[d]xtxt"},
+		},
+	}
+
+	for _, tt := range tests {
+		re := regexp.MustCompile(globToRegexp(tt.glob))
+
+		for _, s := range tt.match {
+			if !re.MatchString(s) {
+				t.Errorf("%q should match %q", tt.glob, s)
+			}
+		}
+
+		for _, s := range tt.miss {
+			if re.MatchString(s) {
+				t.Errorf("%q shouldn't match %q", tt.glob, s)
+			}
+		}
+	}
+}
+
+func TestCompileProfile(t *testing.T) {
+	if _, err := (&Profile{Include: []string{"re:("}}).Compile(); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+
+	if _, err := (&Profile{Exclude: []string{"re:[a-"}}).Compile(); err == nil {
+		t.Error("expected an error for an invalid exclude")
+	}
+
+	if f, err := (*Profile)(nil).Compile(); err != nil || f == nil {
+		t.Errorf("a nil profile: %v, %v", f, err)
+	}
+}
+
+func TestFilterSkips(t *testing.T) {
+	download := func(u string, size int64, refs ...Ref) *Download {
+		d := &Download{Refs: refs}
+		d.URL = u
+		d.OutPath = u[len("https://"):]
+		d.Size = size
+		return d
+	}
+
+	var (
+		image = Ref{Kind: "library", Role: RoleImage}
+		thumb = Ref{Kind: "library", Role: RoleThumbnail}
+		scan  = Ref{Kind: "library", Role: RoleScan}
+		audio = Ref{Kind: "content", Role: RoleAudio}
+		en    = audio.WithLang("en")
+		fr    = audio.WithLang("FR")
+
+		pdf   = download("https://a.example.com/docs/a.pdf", 100, scan)
+		deep  = download("https://a.example.com/docs/sub/b.pdf", 0, scan)
+		mp4   = download("https://b.example.com/videos/c.mp4", 5000, Ref{Kind: "videos", Role: RoleVideo})
+		png   = download("https://a.example.com/img/d.png", 10, thumb)
+		both  = download("https://a.example.com/img/e.png", 10, scan, image)
+		enMP3 = download("https://a.example.com/audio/en.mp3", 10, en)
+		frMP3 = download("https://a.example.com/audio/fr.mp3", 10, fr)
+		multi = download("https://a.example.com/audio/multi.mp3", 10, fr, en)
+		plain = download("https://a.example.com/audio/plain.mp3", 10, audio)
+		fr2   = download("https://a.example.com/audio/fr2.mp3", 10, Ref{Kind: KindScrape, Role: RoleScrape}, fr)
+		scrap = download("https://a.example.com/audio/scraped.mp3", 10, Ref{Kind: KindScrape, Role: RoleScrape})
+		bare  = download("https://a.example.com/bare", 10)
+	)
+
+	tests := []struct {
+		name    string
+		profile *Profile
+		want    map[*Download]string
+	}{
+		{
+			name:    "nothing",
+			profile: nil,
+			want: map[*Download]string{
+				pdf: "", mp4: "", png: "", bare: "", frMP3: "", both: "",
+			},
+		},
+		{
+			name:    "include a single path segment",
+			profile: &Profile{Include: []string{"a.example.com/docs/*"}},
+			want:    map[*Download]string{pdf: "", deep: SkippedInclude, png: SkippedInclude},
+		},
+		{
+			name:    "include across path segments",
+			profile: &Profile{Include: []string{"a.example.com/docs/**"}},
+			want:    map[*Download]string{pdf: "", deep: "", png: SkippedInclude},
+		},
+		{
+			name:    "include urls",
+			profile: &Profile{Include: []string{"https://b.example.com/**"}},
+			want:    map[*Download]string{mp4: "", pdf: SkippedInclude},
+		},
+		{
+			name:    "exclude",
+			profile: &Profile{Exclude: []string{"**/videos/**", "**.png"}},
+			want:    map[*Download]string{mp4: SkippedExclude, png: SkippedExclude, pdf: ""},
+		},
+		{
+			name:    "exclude wins over include",
+			profile: &Profile{Include: []string{"**.pdf"}, Exclude: []string{"**/sub/**"}},
+			want:    map[*Download]string{pdf: "", deep: SkippedExclude, mp4: SkippedInclude},
+		},
+		{
+			name:    "regular expressions",
+			profile: &Profile{Include: []string{`re:\.(pdf|mp4)$`}},
+			want:    map[*Download]string{pdf: "", deep: "", mp4: "", png: SkippedInclude},
+		},
+		{
+			name:    "regular expressions aren't anchored",
+			profile: &Profile{Exclude: []string{"re:docs"}},
+			want:    map[*Download]string{pdf: SkippedExclude, deep: SkippedExclude, png: ""},
+		},
+		{
+			name:    "size, unknown sizes pass",
+			profile: &Profile{MaxFileSize: 100},
+			want:    map[*Download]string{pdf: "", deep: "", mp4: SkippedSize, png: ""},
+		},
+		{
+			name:    "kind groups",
+			profile: &Profile{Kinds: []string{"image"}},
+			want: map[*Download]string{
+				png: "", both: "",
+				pdf: SkippedKind, mp4: SkippedKind, bare: SkippedKind,
+			},
+		},
+		{
+			name:    "roles",
+			profile: &Profile{Kinds: []string{RoleThumbnail, "video"}},
+			want:    map[*Download]string{png: "", mp4: "", pdf: SkippedKind, both: SkippedKind},
+		},
+		{
+			name:    "languages",
+			profile: &Profile{Languages: []string{"EN"}},
+			want: map[*Download]string{
+				enMP3: "", frMP3: SkippedLanguage, multi: "",
+				plain: "", bare: "", pdf: "",
+				fr2: SkippedLanguage, scrap: "",
+			},
+		},
+		{
+			name:    "the first reason wins",
+			profile: &Profile{Exclude: []string{"**.mp4"}, MaxFileSize: 1, Kinds: []string{"image"}},
+			want:    map[*Download]string{mp4: SkippedExclude, pdf: SkippedSize, png: SkippedSize},
+		},
+	}
+
+	for _, tt := range tests {
+		f, err := tt.profile.Compile()
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+
+		for d, want := range tt.want {
+			if got := f.Skips(d); got != want {
+				t.Errorf("%v: %v skipped by %q, want %q", tt.name, d.URL, got, want)
+			}
+
+			if f.Wants(d) != (want == "") {
+				t.Errorf("%v: %v wanted = %v, want %v", tt.name, d.URL, f.Wants(d), want == "")
+			}
+		}
+	}
+}
@@ -1,80 +1,288 @@
 package accords_mirrorrer
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"time"
 
+	"git.vs49688.net/zane/goutils"
 	"git.vs49688.net/zane/goutils/download"
+	"github.com/klauspost/compress/zstd"
 
 	"git.vs49688.net/zane/accords-mirrorrer/library"
 )
 
+var ErrReadOnly = errors.New("state was loaded read-only")
+
 const (
-	CurrentStateVersion = "2"
+	CurrentStateVersion = "3"
+
+	// MaxEntityHistory is the number of previous versions kept for each entity.
+	MaxEntityHistory = 16
 )
 
+// RawEntity wraps an entity's raw pageProps with information about where it came from.
+type RawEntity struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Source    string          `json:"source,omitempty"`
+	Headers   *EntityHeaders  `json:"headers,omitempty"`
+	SHA256    string          `json:"sha256"`
+	Data      json.RawMessage `json:"data"`
+
+	// History contains previous versions of the entity, newest first.
+	History []*RawEntity `json:"history,omitempty"`
+}
+
+// EntityHeaders are the interesting HTTP response headers of an entity fetch.
+type EntityHeaders struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// NewRawEntity builds a RawEntity. info may be nil if the provenance is unknown.
+func NewRawEntity(raw json.RawMessage, info *library.FetchInfo) *RawEntity {
+	e := &RawEntity{
+		SHA256: HashRaw(raw),
+		Data:   raw,
+	}
+
+	if info != nil {
+		e.FetchedAt = info.FetchedAt
+		e.Source = info.URL
+
+		if info.ETag != "" || info.LastModified != "" {
+			e.Headers = &EntityHeaders{
+				ETag:         info.ETag,
+				LastModified: info.LastModified,
+			}
+		}
+	}
+
+	return e
+}
+
+// HashRaw returns the hex SHA256 of the compacted JSON, so formatting doesn't affect it.
+func HashRaw(raw json.RawMessage) string {
+	buf := bytes.Buffer{}
+	if err := json.Compact(&buf, raw); err != nil {
+		buf.Reset()
+		buf.Write(raw)
+	}
+
+	return hex.EncodeToString(goutils.Hash(sha256.New, buf.Bytes()))
+}
+
+// UpdateRawEntity replaces old with next. If the content has changed, old is pushed onto
+// the history, which is deduplicated by hash and bounded by MaxEntityHistory.
+func UpdateRawEntity(old, next *RawEntity) *RawEntity {
+	if old == nil || old == next {
+		return next
+	}
+
+	prev := *old
+	prev.History = nil
+
+	candidates := make([]*RawEntity, 0, len(old.History)+len(next.History)+1)
+	candidates = append(candidates, next.History...)
+	if old.SHA256 != next.SHA256 {
+		candidates = append(candidates, &prev)
+	}
+	candidates = append(candidates, old.History...)
+
+	seen := map[string]struct{}{next.SHA256: {}}
+	history := make([]*RawEntity, 0, len(candidates))
+	for _, e := range candidates {
+		if _, exists := seen[e.SHA256]; exists {
+			continue
+		}
+		seen[e.SHA256] = struct{}{}
+
+		history = append(history, e)
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].FetchedAt.After(history[j].FetchedAt)
+	})
+
+	if len(history) > MaxEntityHistory {
+		history = history[:MaxEntityHistory]
+	}
+
+	if len(history) == 0 {
+		history = nil
+	}
+
+	next.History = history
+	return next
+}
+
+// Versions returns the current version followed by its history, newest first.
+func (e *RawEntity) Versions() []*RawEntity {
+	if e == nil {
+		return nil
+	}
+
+	return append([]*RawEntity{e}, e.History...)
+}
+
+// At returns the version that was current at time t, or nil if there wasn't one.
+// Versions without a fetch time are assumed to be the oldest.
+func (e *RawEntity) At(t time.Time) *RawEntity {
+	for _, v := range e.Versions() {
+		if !v.FetchedAt.After(t) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (e *RawEntity) fetchInfo() *library.FetchInfo {
+	info := &library.FetchInfo{
+		URL:       e.Source,
+		FetchedAt: e.FetchedAt,
+	}
+
+	if e.Headers != nil {
+		info.ETag = e.Headers.ETag
+		info.LastModified = e.Headers.LastModified
+	}
+
+	return info
+}
+
 type State struct {
-	Version    string                     `json:"version,omitempty"`
-	RawFolders map[string]json.RawMessage `json:"folders"`
-	RawContent map[string]json.RawMessage `json:"content"`
+	Version   string               `json:"version,omitempty"`
+	Downloads map[string]*Download `json:"downloads"`
 
-	RawLibrary        map[string]json.RawMessage `json:"library"`
-	RawReader         map[string]json.RawMessage `json:"reader"`
-	RawWiki           map[string]json.RawMessage `json:"wiki"`
-	RawWeaponsStories map[string]json.RawMessage `json:"weapon_stories"`
-	RawChronology     json.RawMessage            `json:"chronology"`
-	RawPosts          map[string]json.RawMessage `json:"posts"`
-	RawVideos         map[string]json.RawMessage `json:"videos"`
-	RawVideoChannels  map[string]json.RawMessage `json:"video_channels"`
+	// Scraped are the URLs found by the last scrape of the asset storage.
+	Scraped []string `json:"scraped,omitempty"`
 
-	Chronicles struct {
-		RawIndex json.RawMessage          `json:"index"`
-		Index    *library.ChroniclesProps `json:"-"`
+	// Profiles are the saved download policies, by name.
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
 
-		RawEntries map[string]json.RawMessage         `json:"entries"`
-		Entries    map[string]*library.ChronicleProps `json:"-"`
-	} `json:"chronicles"`
+	// entities are the raw entities of each registered kind, by name. Singletons use an empty slug.
+	entities map[string]map[string]*RawEntity
 
-	Downloads map[string]*download.DownloadInfo `json:"downloads"`
+	// views are the typed views of entities, by kind name.
+	views map[string]any
+
+	// extra are the top-level values of kinds that aren't registered, kept so they survive a save.
+	extra map[string]json.RawMessage
+
+	readOnly bool
 
-	Folders       map[string]*library.FolderProps       `json:"-"`
-	Content       map[string]*library.ContentProps      `json:"-"`
-	Library       map[string]*library.LibraryProps      `json:"-"`
-	Reader        map[string]*library.ReaderProps       `json:"-"`
-	Wiki          map[string]*library.WikiProps         `json:"-"`
-	WeaponStories map[string]*library.WeaponStoryProps  `json:"-"`
-	Chronology    *library.ChronologyProps              `json:"-"`
-	Posts         map[string]*library.PostProps         `json:"-"`
-	Videos        map[string]*library.VideoProps        `json:"-"`
-	VideoChannels map[string]*library.VideoChannelProps `json:"-"`
+	onFetched EntityFetchedFunc
+
+	// refetchBefore is when an entity must have been fetched since to not be fetched again, if set.
+	refetchBefore time.Time
+}
+
+// EntityFetchedFunc is called after an entity has been fetched and stored.
+type EntityFetchedFunc func(ctx context.Context, kind Kind, slug string, entity *RawEntity)
+
+// OnEntityFetched sets a function to be called whenever an entity of the state is fetched.
+func (s *State) OnEntityFetched(fn EntityFetchedFunc) {
+	s.onFetched = fn
 }
 
-func (s *State) AddDownload(uu string) (*download.DownloadInfo, error) {
-	if di, exists := s.Downloads[uu]; exists {
-		return di, nil
+// RefetchOlderThan makes entities that were fetched longer than age ago be fetched again when
+// they're next needed, so their history picks up changes.
+func (s *State) RefetchOlderThan(age time.Duration) {
+	s.refetchBefore = time.Now().Add(-age)
+}
+
+func (s *State) isStale(e *RawEntity) bool {
+	return !s.refetchBefore.IsZero() && e != nil && e.FetchedAt.Before(s.refetchBefore)
+}
+
+func (s *State) rawEntities(kind string) map[string]*RawEntity {
+	m, exists := s.entities[kind]
+	if !exists {
+		m = map[string]*RawEntity{}
+		s.entities[kind] = m
 	}
 
-	u, err := url.Parse(uu)
-	if err != nil {
-		return nil, err
+	return m
+}
+
+// RawEntities returns the raw entity maps, keyed by kind. Singleton kinds use an empty slug.
+func (s *State) RawEntities() map[string]map[string]*RawEntity {
+	out := make(map[string]map[string]*RawEntity, len(kinds))
+	for _, k := range kinds {
+		out[k.GetName()] = s.rawEntities(k.GetName())
 	}
 
-	di := &download.DownloadInfo{
-		URL:     u.String(),
-		OutPath: path.Join(u.Host, u.Path),
+	return out
+}
+
+// AddDownload adds a download if it doesn't already exist, recording the references.
+func (s *State) AddDownload(uu string, refs ...Ref) (*Download, error) {
+	di, exists := s.Downloads[uu]
+	if !exists {
+		u, err := url.Parse(uu)
+		if err != nil {
+			return nil, err
+		}
+
+		di = &Download{DownloadInfo: download.DownloadInfo{
+			URL:     u.String(),
+			OutPath: path.Join(u.Host, u.Path),
+		}}
+
+		s.Downloads[uu] = di
 	}
 
-	s.Downloads[uu] = di
+	for _, ref := range refs {
+		di.AddRef(ref)
+	}
 
 	return di, nil
 }
 
+// MergeExtra adds the values of the unregistered kinds of src that the state doesn't have, so they
+// survive a merge. It returns the kinds both have with different values, which keep the state's.
+func (s *State) MergeExtra(src *State) []string {
+	var conflicts []string
+	for kind, raw := range src.extra {
+		if existing, exists := s.extra[kind]; exists {
+			if !bytes.Equal(existing, raw) {
+				conflicts = append(conflicts, kind)
+			}
+			continue
+		}
+
+		if s.extra == nil {
+			s.extra = map[string]json.RawMessage{}
+		}
+		s.extra[kind] = raw
+	}
+
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// ClearRefs removes the references from all downloads.
+func (s *State) ClearRefs() {
+	for _, di := range s.Downloads {
+		di.Refs = nil
+	}
+}
+
 func migrateV1(data []byte) ([]byte, error) {
 	type v1Data struct {
 		PageProps json.RawMessage `json:"pageProps"`
@@ -132,8 +340,8 @@ func migrateV1(data []byte) ([]byte, error) {
 		downloads[k] = asset
 	}
 
-	ss := &State{
-		Version:    CurrentStateVersion,
+	ss := &v2State{
+		Version:    "2",
 		RawFolders: fixedIndex,
 		RawLibrary: rawLibrary,
 		Downloads:  downloads,
@@ -141,6 +349,103 @@ func migrateV1(data []byte) ([]byte, error) {
 	return json.Marshal(ss)
 }
 
+type v2State struct {
+	Version    string                     `json:"version,omitempty"`
+	RawFolders map[string]json.RawMessage `json:"folders"`
+	RawContent map[string]json.RawMessage `json:"content"`
+
+	RawLibrary        map[string]json.RawMessage `json:"library"`
+	RawReader         map[string]json.RawMessage `json:"reader"`
+	RawWiki           map[string]json.RawMessage `json:"wiki"`
+	RawWeaponsStories map[string]json.RawMessage `json:"weapon_stories"`
+	RawChronology     json.RawMessage            `json:"chronology"`
+	RawPosts          map[string]json.RawMessage `json:"posts"`
+	RawVideos         map[string]json.RawMessage `json:"videos"`
+	RawVideoChannels  map[string]json.RawMessage `json:"video_channels"`
+
+	Chronicles struct {
+		RawIndex   json.RawMessage            `json:"index"`
+		RawEntries map[string]json.RawMessage `json:"entries"`
+	} `json:"chronicles"`
+
+	Downloads map[string]*download.DownloadInfo `json:"downloads"`
+}
+
+// v3State is the layout of a version 3 state file, with the built-in kinds.
+type v3State struct {
+	Version    string                `json:"version,omitempty"`
+	RawFolders map[string]*RawEntity `json:"folders"`
+	RawContent map[string]*RawEntity `json:"content"`
+
+	RawLibrary        map[string]*RawEntity `json:"library"`
+	RawReader         map[string]*RawEntity `json:"reader"`
+	RawWiki           map[string]*RawEntity `json:"wiki"`
+	RawWeaponsStories map[string]*RawEntity `json:"weapon_stories"`
+	RawChronology     *RawEntity            `json:"chronology"`
+	RawPosts          map[string]*RawEntity `json:"posts"`
+	RawVideos         map[string]*RawEntity `json:"videos"`
+	RawVideoChannels  map[string]*RawEntity `json:"video_channels"`
+
+	Chronicles struct {
+		RawIndex   *RawEntity            `json:"index"`
+		RawEntries map[string]*RawEntity `json:"entries"`
+	} `json:"chronicles"`
+
+	Downloads map[string]*Download `json:"downloads"`
+}
+
+// migrateV2 wraps each bare pageProps in a RawEntity. Provenance is unknown, so only the hash is filled in.
+func migrateV2(data []byte) ([]byte, error) {
+	s := &v2State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+
+	wrap := func(raw json.RawMessage) *RawEntity {
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			return nil
+		}
+
+		return NewRawEntity(raw, nil)
+	}
+
+	wrapMap := func(m map[string]json.RawMessage) map[string]*RawEntity {
+		out := make(map[string]*RawEntity, len(m))
+		for k, v := range m {
+			out[k] = wrap(v)
+		}
+
+		return out
+	}
+
+	ss := &v3State{
+		Version:           "3",
+		RawFolders:        wrapMap(s.RawFolders),
+		RawContent:        wrapMap(s.RawContent),
+		RawLibrary:        wrapMap(s.RawLibrary),
+		RawReader:         wrapMap(s.RawReader),
+		RawWiki:           wrapMap(s.RawWiki),
+		RawWeaponsStories: wrapMap(s.RawWeaponsStories),
+		RawChronology:     wrap(s.RawChronology),
+		RawPosts:          wrapMap(s.RawPosts),
+		RawVideos:         wrapMap(s.RawVideos),
+		RawVideoChannels:  wrapMap(s.RawVideoChannels),
+		Downloads:         make(map[string]*Download, len(s.Downloads)),
+	}
+
+	// A null download has nothing to keep.
+	for k, di := range s.Downloads {
+		if di != nil {
+			ss.Downloads[k] = &Download{DownloadInfo: *di}
+		}
+	}
+
+	ss.Chronicles.RawIndex = wrap(s.Chronicles.RawIndex)
+	ss.Chronicles.RawEntries = wrapMap(s.Chronicles.RawEntries)
+
+	return json.Marshal(ss)
+}
+
 func updateState(data []byte) (*State, error) {
 	type stateVersion struct {
 		Version string `json:"version"`
@@ -160,6 +465,11 @@ again:
 			return nil, err
 		}
 		goto again
+	case "2":
+		if data, err = migrateV2(data); err != nil {
+			return nil, err
+		}
+		goto again
 	case CurrentStateVersion:
 		break
 	default:
@@ -167,159 +477,183 @@ again:
 	}
 
 	ss := &State{}
-	if err := json.Unmarshal(data, ss); err != nil {
+	if err := decodeState(json.NewDecoder(bytes.NewReader(data)), ss); err != nil {
 		return nil, err
 	}
 
 	return ss, nil
 }
 
-func unraw[T any](raw json.RawMessage) (*T, error) {
-	if len(raw) == 0 {
+func unraw[T any](raw *RawEntity) (*T, error) {
+	if raw == nil || len(raw.Data) == 0 {
 		return nil, nil
 	}
 
 	val := new(T)
-	if err := json.Unmarshal(raw, val); err != nil {
+	if err := json.Unmarshal(raw.Data, val); err != nil {
 		return nil, err
 	}
 
-	reflect.ValueOf(val).Elem().FieldByName("Raw").SetBytes(raw)
+	v := reflect.ValueOf(val).Elem()
+	v.FieldByName("Raw").SetBytes(raw.Data)
+	v.FieldByName("Fetch").Set(reflect.ValueOf(raw.fetchInfo()))
 	return val, nil
 }
 
-func unrawMap[T any](raw map[string]json.RawMessage) (map[string]*T, error) {
-	out := make(map[string]*T, len(raw))
-
-	for k, v := range raw {
-		val, err := unraw[T](v)
-		if err != nil {
-			return nil, err
-		}
-
-		out[k] = val
-	}
-
-	return out, nil
+// NewState returns an empty state.
+func NewState() *State {
+	state := &State{}
+	state.init()
+	return state
 }
 
-func LoadState(path string) (*State, error) {
-	b, err := os.ReadFile(filepath.Clean(path))
-	if errors.Is(err, os.ErrNotExist) {
-		b = []byte(`{}`)
-	}
-
-	state, err := updateState(b)
-	if err != nil {
-		return nil, err
+// init fills in any missing maps.
+func (s *State) init() {
+	if s.entities == nil {
+		s.entities = map[string]map[string]*RawEntity{}
 	}
 
-	if state.RawFolders == nil {
-		state.RawFolders = map[string]json.RawMessage{}
+	for _, k := range kinds {
+		s.rawEntities(k.GetName())
 	}
 
-	if state.Folders, err = unrawMap[library.FolderProps](state.RawFolders); err != nil {
-		return nil, err
+	if s.views == nil {
+		s.views = map[string]any{}
 	}
 
-	if state.RawContent == nil {
-		state.RawContent = map[string]json.RawMessage{}
+	if s.Downloads == nil {
+		s.Downloads = map[string]*Download{}
 	}
+}
 
-	if state.Content, err = unrawMap[library.ContentProps](state.RawContent); err != nil {
+// LoadState loads a state file, which may be compressed. A missing file is an empty state.
+// Entities are decoded on first access, so errors in them aren't reported here.
+func LoadState(path string) (*State, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return NewState(), nil
+	} else if err != nil {
 		return nil, err
 	}
+	defer func() { _ = f.Close() }()
 
-	if state.RawLibrary == nil {
-		state.RawLibrary = map[string]json.RawMessage{}
+	// Optimistically decode the current version as it's streamed in, to avoid
+	// holding the entire file in memory. Older versions need a second pass.
+	state := &State{}
+	err = readState(f, func(r io.Reader) error {
+		return decodeState(json.NewDecoder(r), state)
+	})
+	if err == nil && state.Version == CurrentStateVersion {
+		state.init()
+		return state, nil
 	}
 
-	if state.Library, err = unrawMap[library.LibraryProps](state.RawLibrary); err != nil {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
 
-	if state.RawReader == nil {
-		state.RawReader = map[string]json.RawMessage{}
-	}
-
-	if state.Reader, err = unrawMap[library.ReaderProps](state.RawReader); err != nil {
+	var b []byte
+	err = readState(f, func(r io.Reader) error {
+		b, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	if state.RawWiki == nil {
-		state.RawWiki = map[string]json.RawMessage{}
-	}
-
-	if state.Wiki, err = unrawMap[library.WikiProps](state.RawWiki); err != nil {
+	if state, err = updateState(b); err != nil {
 		return nil, err
 	}
 
-	if state.RawWeaponsStories == nil {
-		state.RawWeaponsStories = map[string]json.RawMessage{}
-	}
+	state.init()
+	return state, nil
+}
 
-	if state.WeaponStories, err = unrawMap[library.WeaponStoryProps](state.RawWeaponsStories); err != nil {
+// LoadStateReadOnly loads a state for inspection. It can't be saved.
+func LoadStateReadOnly(path string) (*State, error) {
+	state, err := LoadState(path)
+	if err != nil {
 		return nil, err
 	}
 
-	if state.RawPosts == nil {
-		state.RawPosts = map[string]json.RawMessage{}
-	}
+	state.readOnly = true
+	return state, nil
+}
 
-	if state.Posts, err = unrawMap[library.PostProps](state.RawPosts); err != nil {
-		return nil, err
+// SaveState writes the state, gzip-compressed if the path ends in ".gz", or zstd-compressed
+// if it ends in ".zst" or ".zstd".
+// The file is replaced atomically.
+func SaveState(state *State, path string) error {
+	if state.readOnly {
+		return ErrReadOnly
 	}
 
-	if state.Chronology, err = unraw[library.ChronologyProps](state.RawChronology); err != nil {
-		return nil, err
-	}
+	state.Version = CurrentStateVersion
 
-	if state.Chronicles.Index, err = unraw[library.ChroniclesProps](state.Chronicles.RawIndex); err != nil {
-		return nil, err
+	compression, err := stateCompressionFor(path)
+	if err != nil {
+		return err
 	}
 
-	if state.Chronicles.RawEntries == nil {
-		state.Chronicles.RawEntries = map[string]json.RawMessage{}
-	}
+	tmp := path + ".tmp"
 
-	if state.Chronicles.Entries, err = unrawMap[library.ChronicleProps](state.Chronicles.RawEntries); err != nil {
-		return nil, err
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
 	}
 
-	if state.RawVideos == nil {
-		state.RawVideos = map[string]json.RawMessage{}
+	if err := writeState(f, state, compression); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
 	}
 
-	if state.Videos, err = unrawMap[library.VideoProps](state.RawVideos); err != nil {
-		return nil, err
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
 	}
 
-	if state.RawVideoChannels == nil {
-		state.RawVideoChannels = map[string]json.RawMessage{}
-	}
+	return os.Rename(tmp, path)
+}
 
-	if state.VideoChannels, err = unrawMap[library.VideoChannelProps](state.RawVideoChannels); err != nil {
-		return nil, err
-	}
+// WriteState streams the uncompressed state to w.
+func WriteState(w io.Writer, state *State) error {
+	state.Version = CurrentStateVersion
+	return writeState(w, state, compressionNone)
+}
 
-	if state.Downloads == nil {
-		state.Downloads = map[string]*download.DownloadInfo{}
-	}
+func writeState(w io.Writer, state *State, compression stateCompression) error {
+	bw := bufio.NewWriter(w)
 
-	return state, nil
-}
+	var zw io.WriteCloser
+	var out io.Writer = bw
 
-func SaveState(state *State, path string) error {
-	state.Version = CurrentStateVersion
+	switch compression {
+	case compressionGzip:
+		zw = gzip.NewWriter(bw)
+		out = zw
+	case compressionZstd:
+		enc, err := zstd.NewWriter(bw, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		zw = enc
+		out = zw
+	}
 
-	b, err := json.MarshalIndent(state, "", "  ")
-	if err != nil {
+	if err := encodeState(out, state); err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(path, b, 0600); err != nil {
+	if _, err := io.WriteString(out, "\n"); err != nil {
 		return err
 	}
 
-	return nil
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
 }
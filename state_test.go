@@ -0,0 +1,238 @@
+package accords_mirrorrer
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.vs49688.net/zane/goutils/download"
+)
+
+func readFixture(t *testing.T, name string) map[string]json.RawMessage {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+func unmarshalMap(t *testing.T, raw json.RawMessage) map[string]json.RawMessage {
+	t.Helper()
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// jsonEqual compares two documents, ignoring formatting.
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatal(err)
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
+
+func checkEntity(t *testing.T, state *State, kind, slug string, want json.RawMessage) {
+	t.Helper()
+
+	e := state.RawEntities()[kind][slug]
+	switch {
+	case e == nil:
+		t.Errorf("%v %q is missing", kind, slug)
+	case !jsonEqual(t, e.Data, want):
+		t.Errorf("%v %q = %s, want %s", kind, slug, e.Data, want)
+	case e.SHA256 != HashRaw(e.Data):
+		t.Errorf("%v %q has hash %v, want %v", kind, slug, e.SHA256, HashRaw(e.Data))
+	}
+}
+
+func checkDownloads(t *testing.T, state *State, want map[string]json.RawMessage) {
+	t.Helper()
+
+	var numWanted int
+	for u, raw := range want {
+		if string(raw) == "null" {
+			continue
+		}
+		numWanted += 1
+
+		var di download.DownloadInfo
+		if err := json.Unmarshal(raw, &di); err != nil {
+			t.Fatal(err)
+		}
+
+		if d := state.Downloads[u]; d == nil {
+			t.Errorf("download %v is missing", u)
+		} else if d.DownloadInfo != di {
+			t.Errorf("download %v = %+v, want %+v", u, d.DownloadInfo, di)
+		}
+	}
+
+	if len(state.Downloads) != numWanted {
+		t.Errorf("got %v downloads, want %v", len(state.Downloads), numWanted)
+	}
+}
+
+// checkRoundTrip saves and reloads the state, checking nothing changes.
+func checkRoundTrip(t *testing.T, state *State) {
+	t.Helper()
+
+	for _, name := range []string{"state.json", "state.json.gz", "state.json.zst"} {
+		p := filepath.Join(t.TempDir(), name)
+		if err := SaveState(state, p); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := LoadState(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The entities are re-indented when they're written, so compare them as JSON.
+		before, err := json.Marshal(state.RawEntities())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := json.Marshal(reloaded.RawEntities())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !jsonEqual(t, before, after) {
+			t.Errorf("%v: entities changed after saving", name)
+		}
+
+		if !reflect.DeepEqual(reloaded.Downloads, state.Downloads) {
+			t.Errorf("%v: downloads changed after saving", name)
+		}
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	doc := readFixture(t, "state-v1.json")
+
+	state, err := LoadState(filepath.Join("testdata", "state-v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Version != CurrentStateVersion {
+		t.Errorf("version = %v, want %v", state.Version, CurrentStateVersion)
+	}
+
+	pageProps := func(raw json.RawMessage) json.RawMessage {
+		return unmarshalMap(t, raw)["pageProps"]
+	}
+
+	// The index was keyed by path, the folders are keyed by slug.
+	for p, raw := range unmarshalMap(t, doc["index"]) {
+		checkEntity(t, state, "folders", path.Base(p), pageProps(raw))
+	}
+
+	for slug, raw := range unmarshalMap(t, doc["library"]) {
+		checkEntity(t, state, "library", slug, pageProps(raw))
+	}
+
+	downloads := unmarshalMap(t, doc["content"])
+	for u, raw := range unmarshalMap(t, doc["assets"]) {
+		downloads[u] = raw
+	}
+	checkDownloads(t, state, downloads)
+
+	checkRoundTrip(t, state)
+}
+
+func TestMigrateV2(t *testing.T) {
+	doc := readFixture(t, "state-v2.json")
+
+	state, err := LoadState(filepath.Join("testdata", "state-v2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Version != CurrentStateVersion {
+		t.Errorf("version = %v, want %v", state.Version, CurrentStateVersion)
+	}
+
+	// Every built-in kind is in the fixture, at the same key.
+	for _, k := range Kinds() {
+		keys := strings.Split(k.GetKey(), ".")
+
+		raw := doc[keys[0]]
+		for _, key := range keys[1:] {
+			raw = unmarshalMap(t, raw)[key]
+		}
+
+		if raw == nil {
+			t.Fatalf("%v isn't in the fixture", k.GetName())
+		}
+
+		if k.IsSingleton() {
+			checkEntity(t, state, k.GetName(), "", raw)
+			continue
+		}
+
+		for slug, e := range unmarshalMap(t, raw) {
+			checkEntity(t, state, k.GetName(), slug, e)
+		}
+	}
+
+	checkDownloads(t, state, unmarshalMap(t, doc["downloads"]))
+
+	checkRoundTrip(t, state)
+}
+
+func TestRefetchOlderThan(t *testing.T) {
+	old := &RawEntity{FetchedAt: time.Now().Add(-48 * time.Hour)}
+	recent := &RawEntity{FetchedAt: time.Now().Add(-time.Hour)}
+	migrated := &RawEntity{}
+
+	state := NewState()
+	if state.isStale(old) || state.isStale(migrated) {
+		t.Error("entities are stale without a refetch age")
+	}
+
+	state.RefetchOlderThan(24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		entity *RawEntity
+		want   bool
+	}{
+		{"old", old, true},
+		{"recent", recent, false},
+		{"unknown fetch time", migrated, true},
+		{"missing", nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := state.isStale(tt.entity); got != tt.want {
+			t.Errorf("%v: isStale() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package accords_mirrorrer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type stateCompression int
+
+const (
+	compressionNone stateCompression = iota
+	compressionGzip
+	compressionZstd
+)
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// stateCompressionFor picks the compression of a state file from its extension.
+func stateCompressionFor(path string) (stateCompression, error) {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return compressionGzip, nil
+	case strings.HasSuffix(path, ".zst"), strings.HasSuffix(path, ".zstd"):
+		return compressionZstd, nil
+	default:
+		return compressionNone, nil
+	}
+}
+
+// readState calls fn with the decompressed contents of a state file.
+// The compression is detected by magic bytes.
+func readState(r io.Reader, fn func(r io.Reader) error) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	// Peek can fail on short files, that's fine. The decoder will complain.
+	magic, _ := br.Peek(len(magicZstd))
+
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = zr.Close() }()
+
+		return fn(zr)
+	case bytes.HasPrefix(magic, magicZstd):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		return fn(zr)
+	default:
+		return fn(br)
+	}
+}
+
+// jsonMember is a member of a JSON object being streamed out.
+type jsonMember struct {
+	key   string
+	write func(w io.Writer, indent string) error
+}
+
+func valueMember(key string, v reflect.Value) jsonMember {
+	return jsonMember{
+		key:   key,
+		write: func(w io.Writer, indent string) error { return encodeStream(w, v, indent) },
+	}
+}
+
+// writeObject writes an object the same as json.MarshalIndent(v, indent, "  ") would.
+func writeObject(w io.Writer, indent string, members []jsonMember) error {
+	if len(members) == 0 {
+		_, err := io.WriteString(w, "{}")
+		return err
+	}
+
+	inner := indent + "  "
+
+	for i, m := range members {
+		sep := ",\n"
+		if i == 0 {
+			sep = "{\n"
+		}
+
+		key, err := json.Marshal(m.key)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "%s%s%s: ", sep, inner, key); err != nil {
+			return err
+		}
+
+		if err := m.write(w, inner); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "\n%s}", indent)
+	return err
+}
+
+// encodeStream writes v the same as json.MarshalIndent(v, indent, "  "), but a map
+// entry at a time, so a large map is never entirely in memory.
+func encodeStream(w io.Writer, v reflect.Value, indent string) error {
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String || v.IsNil() {
+		b, err := json.MarshalIndent(v.Interface(), indent, "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(b)
+		return err
+	}
+
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	members := make([]jsonMember, 0, len(keys))
+	for _, k := range keys {
+		members = append(members, valueMember(k.String(), v.MapIndex(k)))
+	}
+
+	return writeObject(w, indent, members)
+}
+
+// expectObject consumes the start of an object. It returns false if it's null instead.
+func expectObject(dec *json.Decoder) (bool, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+
+	if t == nil {
+		return false, nil
+	}
+
+	if t != json.Delim('{') {
+		return false, fmt.Errorf("expected object, got %v", t)
+	}
+
+	return true, nil
+}
+
+// objectKey consumes the key of an object member.
+func objectKey(dec *json.Decoder) (string, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := t.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key, got %v", t)
+	}
+
+	return key, nil
+}
+
+// decodeStream is the counterpart of encodeStream, decoding into the map m an entry at
+// a time, so the decoder never has to buffer the whole map.
+func decodeStream(dec *json.Decoder, m reflect.Value) error {
+	if ok, err := expectObject(dec); err != nil || !ok {
+		return err
+	}
+
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return err
+		}
+
+		elem := reflect.New(m.Type().Elem())
+		if err := dec.Decode(elem.Interface()); err != nil {
+			return fmt.Errorf("%v: %w", key, err)
+		}
+
+		m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), elem.Elem())
+	}
+
+	_, err := dec.Token()
+	return err
+}
+
+// stateNode is a key in the state file, holding either a kind or nested keys.
+type stateNode struct {
+	key      string
+	kind     Kind
+	children []*stateNode
+}
+
+// kindTree arranges the registered kinds by their keys.
+func kindTree() []*stateNode {
+	var roots []*stateNode
+
+	for _, k := range kinds {
+		level := &roots
+
+		parts := strings.Split(k.GetKey(), ".")
+		for i, p := range parts {
+			var n *stateNode
+			for _, c := range *level {
+				if c.key == p {
+					n = c
+					break
+				}
+			}
+
+			if n == nil {
+				n = &stateNode{key: p}
+				*level = append(*level, n)
+			}
+
+			if i == len(parts)-1 {
+				n.kind = k
+			}
+
+			level = &n.children
+		}
+	}
+
+	return roots
+}
+
+func findNode(nodes []*stateNode, key string) *stateNode {
+	for _, n := range nodes {
+		if n.key == key {
+			return n
+		}
+	}
+
+	return nil
+}
+
+func (n *stateNode) member(s *State) jsonMember {
+	if n.kind == nil {
+		return jsonMember{
+			key: n.key,
+			write: func(w io.Writer, indent string) error {
+				members := make([]jsonMember, 0, len(n.children))
+				for _, c := range n.children {
+					members = append(members, c.member(s))
+				}
+
+				return writeObject(w, indent, members)
+			},
+		}
+	}
+
+	m := s.rawEntities(n.kind.GetName())
+	if n.kind.IsSingleton() {
+		return valueMember(n.key, reflect.ValueOf(m[""]))
+	}
+
+	return valueMember(n.key, reflect.ValueOf(m))
+}
+
+func (n *stateNode) decode(dec *json.Decoder, s *State) error {
+	if n.kind == nil {
+		if ok, err := expectObject(dec); err != nil || !ok {
+			return err
+		}
+
+		for dec.More() {
+			key, err := objectKey(dec)
+			if err != nil {
+				return err
+			}
+
+			if c := findNode(n.children, key); c != nil {
+				err = c.decode(dec, s)
+			} else {
+				var discard json.RawMessage
+				err = dec.Decode(&discard)
+			}
+
+			if err != nil {
+				return fmt.Errorf("%v: %w", key, err)
+			}
+		}
+
+		_, err := dec.Token()
+		return err
+	}
+
+	m := s.rawEntities(n.kind.GetName())
+	if n.kind.IsSingleton() {
+		var e *RawEntity
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+
+		if e != nil {
+			m[""] = e
+		}
+
+		return nil
+	}
+
+	return decodeStream(dec, reflect.ValueOf(&m).Elem())
+}
+
+// encodeState writes the state the same as json.MarshalIndent would, had it been a struct
+// with a field for each kind.
+func encodeState(w io.Writer, s *State) error {
+	var members []jsonMember
+
+	if s.Version != "" {
+		members = append(members, valueMember("version", reflect.ValueOf(s.Version)))
+	}
+
+	for _, n := range kindTree() {
+		members = append(members, n.member(s))
+	}
+
+	extra := make([]string, 0, len(s.extra))
+	for k := range s.extra {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+
+	for _, k := range extra {
+		members = append(members, valueMember(k, reflect.ValueOf(s.extra[k])))
+	}
+
+	if len(s.Profiles) > 0 {
+		members = append(members, valueMember("profiles", reflect.ValueOf(s.Profiles)))
+	}
+
+	members = append(members, valueMember("downloads", reflect.ValueOf(s.Downloads)))
+
+	if len(s.Scraped) > 0 {
+		members = append(members, valueMember("scraped", reflect.ValueOf(s.Scraped)))
+	}
+
+	return writeObject(w, "", members)
+}
+
+// decodeState is the counterpart of encodeState.
+func decodeState(dec *json.Decoder, s *State) error {
+	s.init()
+
+	if ok, err := expectObject(dec); err != nil || !ok {
+		return err
+	}
+
+	tree := kindTree()
+
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "version":
+			err = dec.Decode(&s.Version)
+		case "downloads":
+			err = decodeStream(dec, reflect.ValueOf(&s.Downloads).Elem())
+		case "scraped":
+			err = dec.Decode(&s.Scraped)
+		case "profiles":
+			err = dec.Decode(&s.Profiles)
+		default:
+			if n := findNode(tree, key); n != nil {
+				err = n.decode(dec, s)
+				break
+			}
+
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err == nil {
+				if s.extra == nil {
+					s.extra = map[string]json.RawMessage{}
+				}
+				s.extra[key] = raw
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("%v: %w", key, err)
+		}
+	}
+
+	_, err := dec.Token()
+	return err
+}
@@ -0,0 +1,87 @@
+package accords_mirrorrer
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.vs49688.net/zane/accords-mirrorrer/library"
+)
+
+// generateState builds a state with numEntities library entities, each with numDownloads downloads.
+func generateState(numEntities, numDownloads int) *State {
+	state := NewState()
+	fetchedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	entities := state.RawEntities()[Library.Name]
+	for i := 0; i < numEntities; i += 1 {
+		slug := fmt.Sprintf("item-%05d", i)
+
+		var files []string
+		for j := 0; j < numDownloads; j += 1 {
+			u := fmt.Sprintf("https://assets.example.com/library/%s/file-%02d.pdf", slug, j)
+			files = append(files, u)
+
+			d, _ := state.AddDownload(u, Ref{Kind: Library.Name, Slug: slug, Role: RoleScan, Lang: "en"})
+			d.Size = int64(1024 * (i + j + 1))
+			d.SHA256 = fmt.Sprintf("%064x", i*numDownloads+j)
+			d.Completed = true
+		}
+
+		raw, _ := json.Marshal(map[string]any{
+			"slug":        slug,
+			"title":       fmt.Sprintf("Item %d", i),
+			"description": fmt.Sprintf("The description of item %d, long enough to look like the real thing.", i),
+			"files":       files,
+		})
+
+		entities[slug] = NewRawEntity(raw, &library.FetchInfo{
+			URL:       "https://accords-library.com/library/" + slug,
+			FetchedAt: fetchedAt,
+		})
+	}
+
+	return state
+}
+
+var benchmarkStateFiles = []string{"state.json", "state.json.gz", "state.json.zst"}
+
+func BenchmarkSaveState(b *testing.B) {
+	state := generateState(2000, 5)
+
+	for _, name := range benchmarkStateFiles {
+		b.Run(name, func(b *testing.B) {
+			p := filepath.Join(b.TempDir(), name)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i += 1 {
+				if err := SaveState(state, p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoadState(b *testing.B) {
+	state := generateState(2000, 5)
+
+	for _, name := range benchmarkStateFiles {
+		b.Run(name, func(b *testing.B) {
+			p := filepath.Join(b.TempDir(), name)
+			if err := SaveState(state, p); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1 {
+				if _, err := LoadState(p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}